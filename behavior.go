@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -23,6 +24,16 @@ const (
 	flgClobberCommands
 	flgRecursiveWatch
 	flgQuiet
+	flgPollingWatch
+	flgNoDefaultExcludeDirs
+	flgIgnoreFiles
+	flgFirstMatchWins
+	flgDebounce
+	flgQueueRerun
+	flgSettle
+	flgTimerReset
+	flgEachFile
+	flgSupervise
 )
 
 func (flg featureFlag) String() string {
@@ -37,6 +48,26 @@ func (flg featureFlag) String() string {
 		return "flgQuiet"
 	case flgDebugOutput:
 		return "flgDebugOutput"
+	case flgPollingWatch:
+		return "flgPollingWatch"
+	case flgNoDefaultExcludeDirs:
+		return "flgNoDefaultExcludeDirs"
+	case flgIgnoreFiles:
+		return "flgIgnoreFiles"
+	case flgFirstMatchWins:
+		return "flgFirstMatchWins"
+	case flgDebounce:
+		return "flgDebounce"
+	case flgQueueRerun:
+		return "flgQueueRerun"
+	case flgSettle:
+		return "flgSettle"
+	case flgTimerReset:
+		return "flgTimerReset"
+	case flgEachFile:
+		return "flgEachFile"
+	case flgSupervise:
+		return "flgSupervise"
 	default:
 		panic(fmt.Sprintf("unexpected flag, '%d'", int(flg)))
 	}
@@ -45,21 +76,138 @@ func (flg featureFlag) String() string {
 // Encapsulates a given invocation - both its configuration and its currently
 // live interanl state.
 type runDirective struct {
+	Name         string // per --rule NAME; empty for a lone rule
 	Shell        string
+	ShellArgs    []string // as run with COMMAND, per shellArgv()
 	Command      string
+	Stages       []*stage // run in place of COMMAND, per --stage
+	Argv         []string // non-nil if run without a shell, per "--"
 	WatchTargets []string
 	Patterns     []matcher
+	ExcludeDirs  []string
+	OnlyOps      fsnotify.Op // zero means any
+	SkipOps      fsnotify.Op
+	MatchSubject matchSubject
 	Features     map[featureFlag]bool
 	WaitFor      time.Duration
+	PollInterval time.Duration
 
-	LastRun time.Time
-	RunMux  sync.Mutex
-	Cmd     *exec.Cmd
-	Birth   chan os.Process
-	Kills   chan os.Signal
-	Living  *os.Process
-	Death   chan error
-	LastFin time.Time
+	// Quiet window under flgDebounce, and minimum time between runs under
+	// flgClobberCommands; both derived from WaitFor unless set explicitly.
+	DebounceWindow time.Duration
+	ClobberWait    time.Duration
 
-	fsWatcher *fsnotify.Watcher
+	// Under flgSettle, how long a changed file's size and modification time
+	// must hold still before COMMAND runs, and the most we'll wait for that.
+	SettleFor time.Duration
+	SettleMax time.Duration
+
+	// Period of the -n timer trigger; zero means no timer.
+	TimerInterval time.Duration
+
+	// Most COMMANDs run at once, under flgEachFile
+	Jobs int
+
+	// Signal stopping COMMAND (eg: to clobber it), and how long it has to exit
+	// before it's sent SIGKILL instead.
+	StopSignal syscall.Signal
+	KillAfter  time.Duration
+
+	// Longest COMMAND may run before it's stopped as if clobbered; zero means
+	// no limit.
+	RunTimeout time.Duration
+
+	// Under flgSupervise: the delay before restarting COMMAND after it
+	// crashes, which doubles with each crash in a row up to RestartBackoffMax,
+	// and the most restarts in a row; zero means no limit.
+	RestartBackoff    time.Duration
+	RestartBackoffMax time.Duration
+	MaxRestarts       int
+
+	// Conditions for a run to be announced as ready, and shell commands to
+	// run once it is.
+	Probes  []probe
+	OnReady []string
+
+	// Verdict for events no FILE_PATTERN matches, under flgFirstMatchWins
+	ExcludeByDefault bool
+
+	LastRun  time.Time
+	RunMux   sync.Mutex
+	Cmd      *exec.Cmd
+	Running  bool          // until Death is sent; see isRunning()
+	TimedOut bool          // whether this run was stopped for RunTimeout; see hasTimedOut()
+	Living   []*os.Process // each process of COMMAND yet to exit
+	Death    chan error    // new for each run, started by handleFSEvents alone
+	LastFin  time.Time
+
+	// How many times COMMAND has been started, how its last run exited, and
+	// files changed since it was last started; see commandEnv().
+	RunCount     int
+	LastExit     int
+	LastTimedOut bool
+	Changed      []fsEvent
+
+	// How each of Stages fared in the last run, if there are any
+	LastStages []stageResult
+
+	// Under flgSupervise, restarts since COMMAND started crashing over and
+	// over, and when the first of those crashed runs was started.
+	Restarts     int
+	CrashedSince time.Time
+
+	// Events seen while COMMAND was running, and whether the timer fired
+	// meanwhile, under flgQueueRerun
+	Queued      []fsEvent
+	QueuedTimer bool
+
+	fsWatcher watcher
+
+	// Directories currently registered with fsWatcher, keyed by cleaned path.
+	watchedDirs map[string]bool
+
+	// WatchTargets that are files rather than directories, keyed by cleaned
+	// path, and the subset of watchedDirs that are only watched on their behalf.
+	watchedFiles map[string]bool
+	fileOnlyDirs map[string]bool
+
+	// Absolute paths of watchRoots()
+	rootDirs []string
+
+	// Non-nil only under flgIgnoreFiles
+	ignores *ignoreTree
+
+	// Set of Changed, and the file it was last written to for COMMAND.
+	changedSeen map[string]bool
+	changedFile string
+
+	// Tracks whether the current run is ready yet, if there are Probes.
+	ready *readyWatch
+
+	// Fires once the current run has taken RunTimeout, if there's a limit.
+	runTimer *time.Timer
+
+	// Guards Living, Running, TimedOut, shuttingDown, and each run's outcome
+	// (LastRun, LastFin, LastExit, LastTimedOut), which goroutines waiting on
+	// COMMAND - and on signals - update.
+	liveMux sync.Mutex
+
+	// Where output of each of Living is copied from, per pipeOutput(); guarded
+	// by liveMux.
+	outputs map[*exec.Cmd]*outputPipes
+
+	// Set once runonchange is exiting, so COMMAND's exit isn't a crash.
+	shuttingDown bool
+
+	// Closed to stop flgEachFile from starting any more of the current run.
+	halt chan struct{}
+}
+
+// Every rule of a given invocation (see --rule), each run independently of the
+// others off one shared watcher.
+type ruleSet struct {
+	Rules []*runDirective
+	Kills chan os.Signal
+
+	hub *watchHub
 }
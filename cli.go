@@ -3,11 +3,17 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 type parseStage int
@@ -20,6 +26,29 @@ const (
 	psWatchTarget
 	psFilePattern
 	psBadDuration
+	psPollInterval
+	psExcludeDir
+	psOps
+	psMatchSubject
+	psVerdict
+	psDebounceWindow
+	psClobberWait
+	psSettle
+	psSettleMax
+	psTimerInterval
+	psShell
+	psShellTemplate
+	psJobs
+	psRule
+	psStage
+	psSignal
+	psKillAfter
+	psRunTimeout
+	psRestartBackoff
+	psRestartBackoffMax
+	psMaxRestarts
+	psReadyProbe
+	psOnReady
 )
 
 var (
@@ -29,6 +58,12 @@ var (
 	errMissingTargets      = errors.New("No DIR_TO_WATCH set")
 	errTargetsEmptyStrings = errors.New("No non-empty DIR_TO_WATCH set")
 	errMissingShellEnv     = errors.New("$SHELL env variable required")
+	errUnknownShell        = errors.New("unknown shell; pass --shell-template to say how to run COMMAND with it")
+	errShellWithArgv       = errors.New("shell options have no effect on -- ARGV")
+	errRulesDisagree       = errors.New("every rule must agree on -p and -P; pass them before the first --rule")
+	errHookWithoutProbe    = errors.New("--on-ready needs a --ready-* probe to say when COMMAND is ready")
+	errStagesWithCommand   = errors.New("--stage takes the place of COMMAND, so can't be used with it, -- ARGV, or -a")
+	errUnquotablePaths     = errors.New("can't quote placeholders' paths for this shell; pass them with -- ARGV instead")
 )
 
 // golang error representing a cli parsing issue.
@@ -75,6 +110,52 @@ func (stage *parseStage) String() string {
 		return "FILE_PATTERN"
 	case psBadDuration:
 		return "WAIT_DURATION"
+	case psPollInterval:
+		return "POLL_INTERVAL"
+	case psExcludeDir:
+		return "DIR_PATTERN"
+	case psOps:
+		return "OPS"
+	case psMatchSubject:
+		return "SUBJECT"
+	case psVerdict:
+		return "VERDICT"
+	case psDebounceWindow:
+		return "DEBOUNCE_WINDOW"
+	case psClobberWait:
+		return "CLOBBER_WAIT"
+	case psSettle:
+		return "SETTLE_DURATION"
+	case psSettleMax:
+		return "SETTLE_MAX"
+	case psTimerInterval:
+		return "TIMER_INTERVAL"
+	case psShell:
+		return "SHELL"
+	case psShellTemplate:
+		return "TEMPLATE"
+	case psJobs:
+		return "JOBS"
+	case psRule:
+		return "NAME"
+	case psStage:
+		return "STAGE"
+	case psSignal:
+		return "SIGNAL"
+	case psKillAfter:
+		return "KILL_AFTER"
+	case psRunTimeout:
+		return "RUN_TIMEOUT"
+	case psRestartBackoff:
+		return "RESTART_BACKOFF"
+	case psRestartBackoffMax:
+		return "BACKOFF_MAX"
+	case psMaxRestarts:
+		return "MAX_RESTARTS"
+	case psReadyProbe:
+		return "PROBE"
+	case psOnReady:
+		return "HOOK"
 	}
 	panic(fmt.Sprintf("unexpected parseStage found, '%d'", int(*stage)))
 }
@@ -97,8 +178,77 @@ func parseFilePattern(pattern string) (*regexp.Regexp, *parseError) {
 	return match, nil
 }
 
+// Parses golang durations (eg: "250ms", "1.5s", "2m"), or bare numbers which are
+// taken to be seconds (eg: "2").
+func parseDuration(value string, stage parseStage) (time.Duration, *parseError) {
+	var duration time.Duration
+	if secs, e := strconv.ParseFloat(value, 64); e == nil && !math.IsNaN(secs) {
+		if math.Abs(secs) >= math.MaxInt64/float64(time.Second) {
+			return 0, &parseError{
+				Stage: stage,
+				Err: fmt.Errorf("'%s' seconds is out of range; durations can be at most %v",
+					value, time.Duration(math.MaxInt64)),
+			}
+		}
+		duration = time.Duration(secs * float64(time.Second))
+	} else if duration, e = time.ParseDuration(value); e != nil {
+		return 0, &parseError{
+			Stage: stage,
+			Err:   fmt.Errorf("parsing duration: %w", e),
+		}
+	}
+
+	if duration < 0 {
+		return 0, &parseError{
+			Stage: stage,
+			Err:   fmt.Errorf("expected a non-negative duration, but got '%s'", value),
+		}
+	}
+	return duration, nil
+}
+
+// Parses a comma-separated list of filesystem operations, eg: "write,create"
+func parseOps(list string) (fsnotify.Op, *parseError) {
+	var ops fsnotify.Op
+	for _, name := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "create":
+			ops |= fsnotify.Create
+		case "write":
+			ops |= fsnotify.Write
+		case "remove":
+			ops |= fsnotify.Remove
+		case "rename":
+			ops |= fsnotify.Rename
+		case "chmod":
+			ops |= fsnotify.Chmod
+		default:
+			return 0, &parseError{
+				Stage: psOps,
+				Err: fmt.Errorf(
+					"unknown operation '%s' in '%s'; expected any of: %s",
+					name, list, "create, write, remove, rename, chmod"),
+			}
+		}
+	}
+	return ops, nil
+}
+
+// Compiles doublestar glob `pattern` (see globToRegexp) into an anchored
+// regular expression.
+func parseGlobPattern(pattern string) (*regexp.Regexp, *parseError) {
+	match, e := regexp.Compile("^" + globToRegexp(pattern) + "$")
+	if e != nil {
+		return nil, &parseError{
+			Stage: psFilePattern,
+			Err:   fmt.Errorf("glob, '%s': %w", pattern, e),
+		}
+	}
+	return match, nil
+}
+
 func validateDirective(d *runDirective) *parseError {
-	if len(d.Command) < 1 {
+	if len(d.Command) < 1 && d.Argv == nil && len(d.Stages) == 0 {
 		return &parseError{Stage: psCommand, Err: errMissingCommand}
 	}
 
@@ -123,36 +273,75 @@ func validateDirective(d *runDirective) *parseError {
 	}
 }
 
-func buildBaseDirective() (*runDirective, *parseError) {
+func buildBaseDirective(args []string) *runDirective {
 	directive := runDirective{
 		Features:     make(map[featureFlag]bool),
-		WatchTargets: make([]string, len(os.Args)),
-		Kills:        make(chan os.Signal, 1),
-		Patterns:     make([]matcher, len(os.Args)-2 /*at least drop: exec name, COMMAND*/),
+		WatchTargets: make([]string, len(args)+1 /*room for default target*/),
+		Patterns:     make([]matcher, len(args)),
 		WaitFor:      defaultWaitTime,
+		PollInterval: defaultPollInterval,
+		SettleMax:    defaultSettleMax,
+		Jobs:         runtime.NumCPU(),
+		StopSignal:   defaultStopSignal,
+		KillAfter:    defaultKillAfter,
+
+		RestartBackoff:    defaultRestartBackoff,
+		RestartBackoffMax: defaultRestartBackoffMax,
+		MaxRestarts:       defaultMaxRestarts,
 	}
 	directive.WatchTargets[0] = "./"
+	return &directive
+}
 
-	shell := os.Getenv("SHELL")
-	if len(shell) < 1 {
-		return nil, &parseError{
-			Stage: psCommand,
-			Err:   errMissingShellEnv,
+// Settles the shell, and its arguments, that shell mode (ie: a COMMAND rather
+// than -- ARGV) runs COMMAND with: --shell if passed, or else the user's $SHELL.
+func resolveShell(d *runDirective, template string) *parseError {
+	if len(d.Shell) > 0 {
+		shell, e := exec.LookPath(d.Shell)
+		if e != nil {
+			return &parseError{Stage: psShell, Err: e}
+		}
+		d.Shell = shell
+	} else {
+		shell := os.Getenv("SHELL")
+		if len(shell) < 1 {
+			return &parseError{
+				Stage: psCommand,
+				Err:   errMissingShellEnv,
+			}
+		}
+		if _, e := os.Stat(shell); e != nil {
+			// we expect shell to be a path name, per:
+			//   http://pubs.opengroup.org/onlinepubs/9699919799/basedefs/V1_chap08.html#tag_08
+			return &parseError{
+				Stage: psCommand,
+				Err:   fmt.Errorf("$SHELL: %w", e),
+			}
 		}
+		d.Shell = shell
 	}
-	if _, e := os.Stat(shell); e != nil {
-		// we expect shell to be a path name, per:
-		//   http://pubs.opengroup.org/onlinepubs/9699919799/basedefs/V1_chap08.html#tag_08
-		return nil, &parseError{
-			Stage: psCommand,
-			Err:   fmt.Errorf("$SHELL: %w", e),
+
+	if len(template) > 0 {
+		args, e := parseShellTemplate(template)
+		if e != nil {
+			return &parseError{Stage: psShellTemplate, Err: e}
+		}
+		d.ShellArgs = args
+		return nil
+	}
+
+	args, ok := shellArgsFor(d.Shell)
+	if !ok {
+		return &parseError{
+			Stage: psShell,
+			Err:   fmt.Errorf("%s: %w", d.Shell, errUnknownShell),
 		}
 	}
-	directive.Shell = shell
-	return &directive, nil
+	d.ShellArgs = args
+	return nil
 }
 
-func parseCli() (*runDirective, error) {
+func parseCli() (*ruleSet, error) {
 	args := os.Args[1:]
 	if len(args) < 1 {
 		return nil, parseError{
@@ -161,13 +350,148 @@ func parseCli() (*runDirective, error) {
 		}
 	}
 
-	directive, e := buildBaseDirective()
+	rules := &ruleSet{Kills: make(chan os.Signal, 1)}
+	sections, names, e := splitRules(args)
 	if e != nil {
 		return nil, e
 	}
+	for i, section := range sections {
+		run, e := parseRule(section)
+		if e != nil {
+			if len(names[i]) > 0 {
+				return nil, fmt.Errorf("rule '%s': %w", names[i], e)
+			}
+			return nil, e
+		}
+		run.Name = names[i]
+		rules.Rules = append(rules.Rules, run)
+	}
+
+	// Rules share a watcher, so must agree on how it watches
+	lead := rules.Rules[0]
+	for _, run := range rules.Rules[1:] {
+		if run.Features[flgPollingWatch] != lead.Features[flgPollingWatch] ||
+			run.PollInterval != lead.PollInterval {
+			return nil, parseError{Stage: psRule, Err: errRulesDisagree}
+		}
+	}
+	return rules, nil
+}
+
+// Flags taking the argument following them as their value, which may well look
+// like a flag itself (eg: "-r --rule").
+var valueFlags = map[string]bool{
+	"--rule": true, "--stage": true, "--needs": true, "--on-ready": true,
+	"--ready-tcp": true, "--ready-http": true, "--ready-log": true, "--ready-file": true,
+	"--shell": true, "--shell-template": true,
+	"-i": true, "-r": true, "-g": true, "-G": true, "-x": true,
+	"-e": true, "-E": true, "-o": true, "-O": true, "-s": true, "-F": true,
+	"-j": true, "-k": true, "-M": true,
+	"-w": true, "-B": true, "-C": true, "-K": true, "-t": true, "-u": true,
+	"-U": true, "-P": true, "-l": true, "-L": true, "-n": true,
+}
+
+// Splits `args` into those of each --rule, along with each rule's NAME. Args
+// before the first --rule are given to every rule. Without any --rule, there's
+// just the one unnamed rule.
+func splitRules(args []string) ([][]string, []string, *parseError) {
+	first := -1
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break // ARGV can't start rules
+		}
+		if args[i] == "--rule" {
+			first = i
+			break
+		}
+		if valueFlags[args[i]] {
+			i++ // its value can't start a rule either
+		}
+	}
+	if first < 0 {
+		return [][]string{args}, []string{""}, nil
+	}
+
+	shared := args[:first]
+	var sections [][]string
+	var names []string
+	seen := make(map[string]bool)
+	for i := first; i < len(args); i++ {
+		if args[i] == "--" {
+			// ARGV runs to the end, so is the last rule's
+			sections[len(sections)-1] = append(sections[len(sections)-1], args[i:]...)
+			break
+		}
+		if args[i] != "--rule" {
+			sections[len(sections)-1] = append(sections[len(sections)-1], args[i])
+			if valueFlags[args[i]] && i+1 < len(args) {
+				i++
+				sections[len(sections)-1] = append(sections[len(sections)-1], args[i])
+			}
+			continue
+		}
+
+		i++
+		if len(args) == i {
+			return nil, nil, &parseError{
+				Stage: psRule,
+				Err:   fmt.Errorf("no name provided to arg #%d, '--rule'", i),
+			}
+		}
+		name := strings.TrimSpace(args[i])
+		if len(name) == 0 {
+			return nil, nil, expectedNonZero(psRule)
+		}
+		if seen[name] {
+			return nil, nil, &parseError{
+				Stage: psRule,
+				Err:   fmt.Errorf("rule '%s' named more than once", name),
+			}
+		}
+		seen[name] = true
+
+		names = append(names, name)
+		sections = append(sections, append([]string{}, shared...))
+	}
+	return sections, names, nil
+}
+
+// Parses `args` for a single rule; see parseCli.
+func parseRule(args []string) (*runDirective, error) {
+	if len(args) < 1 {
+		return nil, parseError{
+			Stage: psNumArgs,
+			Err:   errMissingCommand,
+		}
+	}
+
+	directive := buildBaseDirective(args)
+
+	// Everything after "--" is ARGV, run as-is rather than by a shell
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--" {
+			if valueFlags[args[i]] {
+				i++ // eg: "-r --" is a pattern, not ARGV
+			}
+			continue
+		}
+		directive.Argv = args[i+1:]
+		if len(directive.Argv) == 0 {
+			return nil, parseError{Stage: psCommand, Err: errMissingCommand}
+		}
+		if len(directive.Argv[0]) == 0 {
+			return nil, expectedNonZero(psCommand)
+		}
+		args = args[:i]
+		break
+	}
 
 	trgtCount := 0
 	ptrnCount := 0
+	debounceWindowSet, clobberWaitSet := false, false
+	var shellTemplate string
+	var stg *stage           // latest --stage, if any
+	var lastPattern *matcher // latest FILE_PATTERN, of the rule or of stg
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 		switch arg {
@@ -183,36 +507,388 @@ func parseCli() (*runDirective, error) {
 		case "-R":
 			directive.Features[flgRecursiveWatch] = true
 
+		case "-b":
+			directive.Features[flgDebounce] = true
+
+		case "-Q":
+			directive.Features[flgQueueRerun] = true
+
+		case "-N":
+			directive.Features[flgTimerReset] = true
+
+		case "-a":
+			directive.Features[flgEachFile] = true
+
+		case "-S":
+			directive.Features[flgSupervise] = true
+
+		case "-M":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psMaxRestarts,
+					Err:   fmt.Errorf("no restart count provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			restarts, e := strconv.Atoi(args[i])
+			if e != nil {
+				return nil, parseError{Stage: psMaxRestarts, Err: e}
+			}
+			if restarts < 0 {
+				return nil, parseError{
+					Stage: psMaxRestarts,
+					Err:   fmt.Errorf("expected a non-negative count, but got %d", restarts),
+				}
+			}
+			directive.MaxRestarts = restarts
+
+		case "-j":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psJobs,
+					Err:   fmt.Errorf("no job count provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			jobs, e := strconv.Atoi(args[i])
+			if e != nil {
+				return nil, parseError{Stage: psJobs, Err: e}
+			}
+			if jobs < 1 {
+				return nil, expectedNonZero(psJobs)
+			}
+			directive.Jobs = jobs
+
+		case "-k":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psSignal,
+					Err:   fmt.Errorf("no signal provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			sig, e := parseSignal(args[i])
+			if e != nil {
+				return nil, parseError{Stage: psSignal, Err: e}
+			}
+			directive.StopSignal = sig
+
 		case "-q":
 			directive.Features[flgQuiet] = true
 
-		case "-h", "h", "--help", "help":
-			return nil, parseError{Stage: psHelp, errState: errHelpRequested}
+		case "-p":
+			directive.Features[flgPollingWatch] = true
+
+		case "-X":
+			directive.Features[flgNoDefaultExcludeDirs] = true
+
+		case "-I":
+			directive.Features[flgIgnoreFiles] = true
+
+		case "-f":
+			directive.Features[flgFirstMatchWins] = true
+
+		case "-F":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psVerdict,
+					Err:   fmt.Errorf("no verdict provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			switch args[i] {
+			case verdictStr(false):
+				directive.ExcludeByDefault = false
+			case verdictStr(true):
+				directive.ExcludeByDefault = true
+			default:
+				return nil, parseError{
+					Stage: psVerdict,
+					Err:   fmt.Errorf("expected include or exclude, but got '%s'", args[i]),
+				}
+			}
+
+		case "-s":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psMatchSubject,
+					Err:   fmt.Errorf("no subject provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			switch args[i] {
+			case msRelative.String():
+				directive.MatchSubject = msRelative
+			case msBasename.String():
+				directive.MatchSubject = msBasename
+			case msAbsolute.String():
+				directive.MatchSubject = msAbsolute
+			default:
+				return nil, parseError{
+					Stage: psMatchSubject,
+					Err: fmt.Errorf(
+						"expected one of %s, %s, or %s; but got '%s'",
+						msBasename, msRelative, msAbsolute, args[i]),
+				}
+			}
+
+		case "-e", "-E", "-o", "-O":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psOps,
+					Err:   fmt.Errorf("no operations provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			ops, e := parseOps(args[i])
+			if e != nil {
+				return nil, e
+			}
+
+			switch arg {
+			case "-e":
+				directive.OnlyOps |= ops
+			case "-E":
+				directive.SkipOps |= ops
+			default: // scope the preceding FILE_PATTERN
+				if lastPattern == nil {
+					return nil, parseError{
+						Stage: psOps,
+						Err:   fmt.Errorf("%s must follow a FILE_PATTERN it applies to", arg),
+					}
+				}
+				if arg == "-O" {
+					ops = allOps &^ ops
+				}
+				lastPattern.Ops = ops
+			}
+
+		case "--stage":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psStage,
+					Err:   fmt.Errorf("no name provided to arg #%d, '%s'", i, arg),
+				}
+			}
+			name := strings.TrimSpace(args[i])
+			if len(name) == 0 {
+				return nil, expectedNonZero(psStage)
+			}
+			if strings.Contains(name, ",") {
+				return nil, parseError{
+					Stage: psStage,
+					Err:   fmt.Errorf("stage names can't contain commas, but got '%s'", name),
+				}
+			}
+			if directive.stageIndex(name) >= 0 {
+				return nil, parseError{
+					Stage: psStage,
+					Err:   fmt.Errorf("stage '%s' named more than once", name),
+				}
+			}
+			if stg != nil && len(stg.Command) == 0 {
+				return nil, parseError{
+					Stage: psStage,
+					Err:   fmt.Errorf("no command provided to stage '%s'", stg.Name),
+				}
+			}
+			stg = &stage{Name: name}
+			directive.Stages = append(directive.Stages, stg)
+			lastPattern = nil
+
+		case "--needs":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psStage,
+					Err:   fmt.Errorf("no stages provided to arg #%d, '%s'", i, arg),
+				}
+			}
+			if stg == nil {
+				return nil, parseError{
+					Stage: psStage,
+					Err:   fmt.Errorf("%s must follow a --stage it applies to", arg),
+				}
+			}
+			for _, name := range strings.Split(args[i], ",") {
+				name = strings.TrimSpace(name)
+				if j := directive.stageIndex(name); j < 0 || directive.Stages[j] == stg {
+					return nil, parseError{
+						Stage: psStage,
+						Err: fmt.Errorf(
+							"stage '%s' needs '%s', which isn't an earlier --stage", stg.Name, name),
+					}
+				}
+				stg.Needs = append(stg.Needs, name)
+			}
 
-		case "-w":
+		case "-x":
 			i++
 			if len(args) == i {
 				return nil, parseError{
-					Stage: psBadDuration,
+					Stage: psExcludeDir,
 					Err:   fmt.Errorf("no pattern provided to arg #%d, '%s'", i, arg),
 				}
 			}
+			if _, e := filepath.Match(args[i], ""); e != nil {
+				return nil, parseError{
+					Stage: psExcludeDir,
+					Err:   fmt.Errorf("pattern, '%s': %w", args[i], e),
+				}
+			}
+			directive.ExcludeDirs = append(directive.ExcludeDirs, args[i])
 
-			waitFor, e := strconv.Atoi(args[i])
+		case "--ready-tcp", "--ready-http", "--ready-log", "--ready-file":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psReadyProbe,
+					Err:   fmt.Errorf("no probe provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			kind := map[string]probeKind{
+				"--ready-tcp":  pkTCP,
+				"--ready-http": pkHTTP,
+				"--ready-log":  pkLog,
+				"--ready-file": pkFile,
+			}[arg]
+			p, e := parseProbe(kind, args[i])
 			if e != nil {
 				return nil, parseError{
-					Stage: psBadDuration,
-					Err:   fmt.Errorf("parsing -w duration: %w", e),
+					Stage: psReadyProbe,
+					Err:   fmt.Errorf("%s '%s': %w", arg, args[i], e),
+				}
+			}
+			directive.Probes = append(directive.Probes, p)
+
+		case "--on-ready":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: psOnReady,
+					Err:   fmt.Errorf("no hook provided to arg #%d, '%s'", i, arg),
+				}
+			}
+			if len(strings.TrimSpace(args[i])) == 0 {
+				return nil, expectedNonZero(psOnReady)
+			}
+			directive.OnReady = append(directive.OnReady, args[i])
+
+		case "--shell", "--shell-template":
+			stage := psShell
+			if arg == "--shell-template" {
+				stage = psShellTemplate
+			}
+
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: stage,
+					Err:   fmt.Errorf("no %s provided to arg #%d, '%s'", stage.String(), i, arg),
 				}
 			}
-			directive.WaitFor = time.Duration(waitFor) * time.Second
+			if len(strings.TrimSpace(args[i])) == 0 {
+				return nil, expectedNonZero(stage)
+			}
+
+			if arg == "--shell" {
+				directive.Shell = args[i]
+			} else {
+				shellTemplate = args[i]
+			}
+
+		case "-h", "h", "--help", "help":
+			return nil, parseError{Stage: psHelp, errState: errHelpRequested}
+
+		case "-w", "-B", "-C", "-K", "-t", "-u", "-U", "-P", "-l", "-L", "-n":
+			var stage parseStage
+			switch arg {
+			case "-w":
+				stage = psBadDuration
+			case "-B":
+				stage = psDebounceWindow
+			case "-C":
+				stage = psClobberWait
+			case "-K":
+				stage = psKillAfter
+			case "-t":
+				stage = psRunTimeout
+			case "-u":
+				stage = psRestartBackoff
+			case "-U":
+				stage = psRestartBackoffMax
+			case "-P":
+				stage = psPollInterval
+			case "-l":
+				stage = psSettle
+			case "-L":
+				stage = psSettleMax
+			case "-n":
+				stage = psTimerInterval
+			}
 
-		case "-i":
-			fallthrough
-		case "-r":
+			i++
+			if len(args) == i {
+				return nil, parseError{
+					Stage: stage,
+					Err:   fmt.Errorf("no duration provided to arg #%d, '%s'", i, arg),
+				}
+			}
+
+			duration, e := parseDuration(args[i], stage)
+			if e != nil {
+				return nil, e
+			}
+
+			switch arg {
+			case "-w":
+				directive.WaitFor = duration
+			case "-B":
+				directive.DebounceWindow = duration
+				directive.Features[flgDebounce] = true
+				debounceWindowSet = true
+			case "-C":
+				directive.ClobberWait = duration
+				clobberWaitSet = true
+			case "-K":
+				directive.KillAfter = duration
+			case "-t":
+				directive.RunTimeout = duration
+			case "-u":
+				directive.RestartBackoff = duration
+			case "-U":
+				directive.RestartBackoffMax = duration
+			case "-P":
+				if duration == 0 {
+					return nil, expectedNonZero(psPollInterval)
+				}
+				directive.PollInterval = duration
+			case "-l":
+				if duration == 0 {
+					return nil, expectedNonZero(psSettle)
+				}
+				directive.SettleFor = duration
+				directive.Features[flgSettle] = true
+			case "-L":
+				directive.SettleMax = duration
+			case "-n":
+				if duration == 0 {
+					return nil, expectedNonZero(psTimerInterval)
+				}
+				directive.TimerInterval = duration
+			}
+
+		case "-i", "-r", "-g", "-G":
 			var m matcher
-			if arg == "-i" {
-				// TODO(zacsh) double-check this works; seems like buggy `case` usage...
+			if arg == "-i" || arg == "-G" {
 				m.IsIgnore = true
 			}
 
@@ -226,15 +902,28 @@ func parseCli() (*runDirective, error) {
 
 			ptrnCount++
 			ptrnStr := args[i] // TODO(zacsh) remove this variable
-			ptrn, e := parseFilePattern(ptrnStr)
+			parse := parseFilePattern
+			if arg == "-g" || arg == "-G" {
+				m.Kind = mkGlob
+				parse = parseGlobPattern
+			}
+			ptrn, e := parse(ptrnStr)
 			if e != nil {
 				return nil, e
 			}
 
 			m.Expr = ptrn
+			m.Source = ptrnStr
+			if stg != nil {
+				stg.Patterns = append(stg.Patterns, m)
+				lastPattern = &stg.Patterns[len(stg.Patterns)-1]
+				ptrnCount-- // not the rule's
+				continue
+			}
 			directive.Patterns[ptrnCount-1] = m
+			lastPattern = &directive.Patterns[ptrnCount-1]
 
-			// positional args: COMMAND, [DIR_TO_WATCH, ...]
+			// positional args: [COMMAND], [DIR_TO_WATCH, ...]
 		default:
 			if len(arg) == 0 {
 				return nil, parseError{
@@ -249,7 +938,14 @@ func parseCli() (*runDirective, error) {
 				}
 			}
 
-			if len(directive.Command) == 0 { // arg: COMMAND
+			if stg != nil && len(stg.Command) == 0 { // arg: the stage's command
+				stg.Command = strings.TrimSpace(args[i])
+				if len(stg.Command) < 1 {
+					return nil, expectedNonZero(psStage)
+				}
+				continue
+			}
+			if stg == nil && len(directive.Command) == 0 && directive.Argv == nil { // arg: COMMAND
 				directive.Command = strings.TrimSpace(args[i])
 				if len(directive.Command) < 1 {
 					return nil, expectedNonZero(psCommand)
@@ -266,10 +962,10 @@ func parseCli() (*runDirective, error) {
 			if e != nil {
 				return nil, parseError{Stage: psWatchTarget, Err: e}
 			}
-			if !watchTarget.IsDir() {
+			if !watchTarget.IsDir() && !watchTarget.Mode().IsRegular() {
 				return nil, parseError{
 					Stage: psWatchTarget,
-					Err:   fmt.Errorf("target must be a directory, but got: %s", watchTargetPath),
+					Err:   fmt.Errorf("target must be a directory or regular file, but got: %s", watchTargetPath),
 				}
 			}
 			trgtCount++
@@ -277,6 +973,14 @@ func parseCli() (*runDirective, error) {
 		}
 	}
 
+	// Unless asked otherwise, these follow WAIT_DURATION
+	if !debounceWindowSet {
+		directive.DebounceWindow = directive.WaitFor
+	}
+	if !clobberWaitSet {
+		directive.ClobberWait = 2 * directive.WaitFor
+	}
+
 	if ptrnCount == 0 {
 		directive.Patterns = nil
 	} else {
@@ -289,9 +993,49 @@ func parseCli() (*runDirective, error) {
 		directive.WatchTargets = directive.WatchTargets[:trgtCount] // slice off excess
 	}
 
+	if stg != nil {
+		if len(stg.Command) == 0 {
+			return nil, parseError{
+				Stage: psStage,
+				Err:   fmt.Errorf("no command provided to stage '%s'", stg.Name),
+			}
+		}
+		if len(directive.Command) > 0 || directive.Argv != nil || directive.Features[flgEachFile] {
+			return nil, parseError{Stage: psStage, Err: errStagesWithCommand}
+		}
+	}
+
 	if e := validateDirective(directive); e != nil {
 		return nil, e
 	}
 
+	if len(directive.OnReady) > 0 && len(directive.Probes) == 0 {
+		return nil, parseError{Stage: psOnReady, Err: errHookWithoutProbe}
+	}
+
+	// Hooks need a shell, even alongside -- ARGV
+	if directive.Argv == nil || len(directive.OnReady) > 0 {
+		if e := resolveShell(directive, shellTemplate); e != nil {
+			return nil, e
+		}
+	} else if len(directive.Shell) > 0 || len(shellTemplate) > 0 {
+		return nil, parseError{Stage: psShell, Err: errShellWithArgv}
+	}
+
+	if directive.Argv == nil && quoterFor(directive.Shell) == nil {
+		commands := []string{directive.Command}
+		for _, s := range directive.Stages {
+			commands = append(commands, s.Command)
+		}
+		for _, c := range commands {
+			if usesPlaceholders(c) {
+				return nil, parseError{
+					Stage: psCommand,
+					Err:   fmt.Errorf("%s: %w", directive.Shell, errUnquotablePaths),
+				}
+			}
+		}
+	}
+
 	return directive, nil
 }
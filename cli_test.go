@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string // in the error, if one's expected
+	}{
+		{value: "2", want: 2 * time.Second},
+		{value: "0", want: 0},
+		{value: "1.5", want: 1500 * time.Millisecond},
+		{value: "0.25", want: 250 * time.Millisecond},
+		{value: "1e3", want: 1000 * time.Second},
+		{value: "250ms", want: 250 * time.Millisecond},
+		{value: "2m", want: 2 * time.Minute},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "9e9", want: 9e9 * time.Second},
+		{value: "-2", err: "non-negative"},
+		{value: "-1s", err: "non-negative"},
+		{value: "1e12", err: "out of range"},
+		{value: "-1e12", err: "out of range"},
+		{value: "inf", err: "out of range"},
+		{value: "NaN", err: "parsing duration"},
+		{value: "soon", err: "parsing duration"},
+		{value: "", err: "parsing duration"},
+		{value: "3000000h", err: "parsing duration"},
+	}
+	for _, tt := range tests {
+		got, e := parseDuration(tt.value, psBadDuration)
+		if len(tt.err) > 0 {
+			if e == nil || !strings.Contains(e.Error(), tt.err) {
+				t.Errorf("'%s': got %v and error %v, want an error about %s", tt.value, got, e, tt.err)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("'%s': unexpected error: %v", tt.value, e)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("'%s': got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRules(t *testing.T) {
+	tests := []struct {
+		args     []string
+		sections [][]string
+		names    []string
+		err      string
+	}{
+		{
+			args:     []string{"make", "-c"},
+			sections: [][]string{{"make", "-c"}},
+			names:    []string{""},
+		},
+		{
+			args:     []string{"-c", "--rule", "a", "make", "--rule", "b", "ls", "src"},
+			sections: [][]string{{"-c", "make"}, {"-c", "ls", "src"}},
+			names:    []string{"a", "b"},
+		},
+		{ // a pattern of "--rule"
+			args:     []string{"make", "-r", "--rule"},
+			sections: [][]string{{"make", "-r", "--rule"}},
+			names:    []string{""},
+		},
+		{
+			args:     []string{"-g", "--rule", "--rule", "a", "make", "-r", "--rule", "--rule", "b", "ls"},
+			sections: [][]string{{"-g", "--rule", "make", "-r", "--rule"}, {"-g", "--rule", "ls"}},
+			names:    []string{"a", "b"},
+		},
+		{ // ARGV can't start rules, nor can anything in it
+			args:     []string{"--", "echo", "--rule", "a"},
+			sections: [][]string{{"--", "echo", "--rule", "a"}},
+			names:    []string{""},
+		},
+		{
+			args:     []string{"--rule", "a", "--", "echo", "--rule", "b"},
+			sections: [][]string{{"--", "echo", "--rule", "b"}},
+			names:    []string{"a"},
+		},
+		{args: []string{"--rule"}, err: "no name"},
+		{args: []string{"--rule", " "}, err: "non-zero"},
+		{args: []string{"--rule", "a", "x", "--rule", "a", "y"}, err: "more than once"},
+	}
+	for _, tt := range tests {
+		sections, names, e := splitRules(tt.args)
+		if len(tt.err) > 0 {
+			if e == nil || !strings.Contains(e.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want one about %s", tt.args, e, tt.err)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("%q: unexpected error: %v", tt.args, e)
+			continue
+		}
+		if !reflect.DeepEqual(sections, tt.sections) || !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%q: got %q named %q, want %q named %q",
+				tt.args, sections, names, tt.sections, tt.names)
+		}
+	}
+}
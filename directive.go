@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"os"
 	"strings"
-
-	"github.com/fsnotify/fsnotify"
 )
 
 func (c *runDirective) debugStr() string {
@@ -21,6 +19,15 @@ func (c *runDirective) debugStr() string {
 			matchStr[:len(matchStr)-1 /*chop off trailing comma*/])
 	}
 
+	stagesStr := "n/a"
+	if len(c.Stages) > 0 {
+		stagesStr = ""
+		for _, s := range c.Stages {
+			stagesStr = fmt.Sprintf("%s\n\t%v,", stagesStr, s)
+		}
+		stagesStr = stagesStr[:len(stagesStr)-1] + "\n  "
+	}
+
 	var features string
 	for k, v := range c.Features {
 		if v {
@@ -34,40 +41,125 @@ func (c *runDirective) debugStr() string {
 
 	return fmt.Sprintf(`
   run.Command:                "%s"
+  run.Stages:                 [%s]
+  run.Argv:                    %q
   run.WatchTargets' Name()s:  [%s
   ]
   run.FilePatterns:           [%s]
+  run.ExcludeDirs:            %q
+  run.MatchSubject:            %s
+  run.ExcludeByDefault:        %t
+  run.OnlyOps:                 %s
+  run.SkipOps:                 %s
   run.Shell:                  "%s"
+  run.ShellArgs:               %q
   run.WaitFor:                 %s
+  run.DebounceWindow:          %s
+  run.ClobberWait:             %s
+  run.SettleFor:               %s
+  run.SettleMax:               %s
+  run.TimerInterval:           %s
+  run.Jobs:                    %d
+  run.StopSignal:              %s
+  run.KillAfter:               %s
+  run.RunTimeout:              %s
+  run.RestartBackoff:          %s
+  run.RestartBackoffMax:       %s
+  run.MaxRestarts:             %d
+  run.Probes:                  %v
+  run.OnReady:                 %q
+  run.PollInterval:            %s
   run.Features:                %s
   `, c.Command,
+		stagesStr,
+		c.Argv,
 		fmt.Sprintf("\n\t%s", strings.Join(c.WatchTargets, ",\n\t")),
 		matchStr,
+		c.ExcludeDirs,
+		c.MatchSubject,
+		c.ExcludeByDefault,
+		c.OnlyOps,
+		c.SkipOps,
 		c.Shell,
+		c.ShellArgs,
 		c.WaitFor,
+		c.DebounceWindow,
+		c.ClobberWait,
+		c.SettleFor,
+		c.SettleMax,
+		c.TimerInterval,
+		c.Jobs,
+		signalName(c.StopSignal),
+		c.KillAfter,
+		c.RunTimeout,
+		c.RestartBackoff,
+		c.RestartBackoffMax,
+		c.MaxRestarts,
+		c.Probes,
+		c.OnReady,
+		c.PollInterval,
 		features)
 }
 
-func (run *runDirective) isRejected(chain []matcher, e fsnotify.Event) bool {
+// Whether `e` is for operations that neither -e nor -E OPS allow.
+func (run *runDirective) isOpRejected(e fsEvent) bool {
+	allowed := allOps
+	if run.OnlyOps != 0 {
+		allowed = run.OnlyOps
+	}
+	allowed &^= run.SkipOps
+
+	if e.Op&allowed != 0 {
+		return false
+	}
+	if run.Features[flgDebugOutput] {
+		fmt.Fprintf(os.Stderr, "OPDROP[%s]\n", e.Op)
+	} else {
+		run.tick(tickDropOp)
+	}
+	return true
+}
+
+func (run *runDirective) isRejected(chain []matcher, e fsEvent) bool {
+	return run.rejects(chain, e, true /*report*/)
+}
+
+// Whether `chain` rejects `e`, as isRejected, but only explaining why (ie:
+// debug output or ticks) if `report`.
+func (run *runDirective) rejects(chain []matcher, e fsEvent, report bool) bool {
+	if run.Features[flgFirstMatchWins] {
+		return run.isRejectedByFirstMatch(chain, e, report)
+	}
+
 	if len(chain) == 0 {
 		return false
 	}
 
+	subject := e.subject(run.MatchSubject)
+
 	for i, p := range chain {
+		if p.Ops != 0 && e.Op&p.Ops == 0 {
+			continue // not a matcher for this sort of event
+		}
+
 		if p.IsIgnore {
-			if p.Expr.MatchString(e.Name) {
-				if run.Features[flgDebugOutput] {
-					fmt.Fprintf(os.Stderr, "IGNR[%d]\n", i)
-				} else {
+			if p.Expr.MatchString(subject) {
+				switch {
+				case !report:
+				case run.Features[flgDebugOutput]:
+					fmt.Fprintf(os.Stderr, "IGNR[%d] '%s'\n", i, subject)
+				default:
 					run.tick(tickDropPatternIgnore)
 				}
 				return true
 			}
 		} else {
-			if !p.Expr.MatchString(e.Name) {
-				if run.Features[flgDebugOutput] {
-					fmt.Fprintf(os.Stderr, "MISS[%d]\n", i)
-				} else {
+			if !p.Expr.MatchString(subject) {
+				switch {
+				case !report:
+				case run.Features[flgDebugOutput]:
+					fmt.Fprintf(os.Stderr, "MISS[%d] '%s'\n", i, subject)
+				default:
 					run.tick(tickDropPatternRestric)
 				}
 				return true
@@ -76,3 +168,47 @@ func (run *runDirective) isRejected(chain []matcher, e fsnotify.Event) bool {
 	}
 	return false
 }
+
+// Like isRejected, but for flgFirstMatchWins: each matcher is a rule to include
+// (-r, -g) or exclude (-i, -G), and the first rule to match decides. Events no
+// rule matches get the default verdict, per -F.
+func (run *runDirective) isRejectedByFirstMatch(
+	chain []matcher, e fsEvent, report bool) bool {
+	subject := e.subject(run.MatchSubject)
+
+	for i, p := range chain {
+		if p.Ops != 0 && e.Op&p.Ops == 0 {
+			continue // not a matcher for this sort of event
+		}
+		if !p.Expr.MatchString(subject) {
+			continue
+		}
+
+		switch {
+		case !report:
+		case run.Features[flgDebugOutput]:
+			fmt.Fprintf(os.Stderr, "RULE[%d] %v decided %s on '%s'\n",
+				i, p, verdictStr(p.IsIgnore), subject)
+		case p.IsIgnore:
+			run.tick(tickDropPatternIgnore)
+		}
+		return p.IsIgnore
+	}
+
+	switch {
+	case !report:
+	case run.Features[flgDebugOutput]:
+		fmt.Fprintf(os.Stderr, "RULE[default] decided %s on '%s'\n",
+			verdictStr(run.ExcludeByDefault), subject)
+	case run.ExcludeByDefault:
+		run.tick(tickDropPatternRestric)
+	}
+	return run.ExcludeByDefault
+}
+
+func verdictStr(exclude bool) string {
+	if exclude {
+		return "exclude"
+	}
+	return "include"
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+)
+
+// Starts COMMAND once per file in `changed`, per flgEachFile, running at most
+// run.Jobs at a time; doesn't wait on them. Once all have exited, the first
+// failure among them (if any) is reported on run.Death.
+func (run *runDirective) runEach(
+	msgStdout bool, changed []fsEvent, changedFile string) {
+	death, halt := run.Death, run.halt
+
+	var (
+		mux     sync.Mutex
+		failure error
+	)
+	fail := func(e error) {
+		mux.Lock()
+		defer mux.Unlock()
+		if failure == nil {
+			failure = e
+		}
+	}
+
+	go func() {
+		slots := make(chan struct{}, run.Jobs)
+		var done sync.WaitGroup
+	files:
+		for i := range changed {
+			select {
+			case <-halt:
+				break files
+			case slots <- struct{}{}:
+			}
+
+			cmd, display := run.newCmd(run.Command, &changed[i], changed, changedFile)
+			if msgStdout {
+				run.messageRunning(display)
+			}
+			if e := run.start(cmd); e != nil {
+				fail(e)
+				<-slots
+				continue
+			}
+			select {
+			case <-halt: // clobbered just as we started it
+				run.stopLate(cmd)
+			default:
+			}
+
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				if e := run.wait(cmd); e != nil {
+					fail(e)
+				}
+				<-slots
+			}()
+		}
+		done.Wait()
+		run.reap(msgStdout, death, failure)
+	}()
+}
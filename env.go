@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/fatih/color"
+)
+
+// Environment variables COMMAND is run with, describing why it's being run.
+const (
+	envEventOp      = "RUNONCHANGE_EVENT_OP"       // eg: "WRITE"; empty if not run for an event
+	envEventPath    = "RUNONCHANGE_EVENT_PATH"     // absolute path of the event's file
+	envWatchRoot    = "RUNONCHANGE_WATCH_ROOT"     // absolute path of its DIR_TO_WATCH
+	envRunCount     = "RUNONCHANGE_RUN_COUNT"      // 1 for the startup run, and so on
+	envLastExit     = "RUNONCHANGE_LAST_EXIT"      // previous run's exit status; empty if none
+	envLastTimedOut = "RUNONCHANGE_LAST_TIMED_OUT" // "1" if the previous run hit -t; empty otherwise
+	envChangedFiles = "RUNONCHANGE_CHANGED_FILES"  // file listing paths changed since the last run
+	envStage        = "RUNONCHANGE_STAGE"          // name of the --stage being run, if any
+)
+
+// Remembers the file `ev` is for among those changed since COMMAND last ran.
+func (run *runDirective) recordChange(ev fsEvent) {
+	run.RunMux.Lock()
+	defer run.RunMux.Unlock()
+
+	if run.changedSeen == nil {
+		run.changedSeen = make(map[string]bool)
+	}
+	if run.changedSeen[ev.Abs] {
+		return
+	}
+	run.changedSeen[ev.Abs] = true
+	run.Changed = append(run.Changed, ev)
+}
+
+// Empties out run.Changed, returning what it held. Callers must hold RunMux.
+func (run *runDirective) takeChanged() []fsEvent {
+	changed := run.Changed
+	run.Changed, run.changedSeen = nil, nil
+	return changed
+}
+
+// The environment to run COMMAND with on behalf of `ev`, nil if it's not being
+// run for a filesystem event, and with changed files listed in `changedFile`.
+func (run *runDirective) commandEnv(ev *fsEvent, changedFile string) []string {
+	var op, path, root, lastExit, lastTimedOut string
+	if ev != nil {
+		op, path, root = ev.Op.String(), ev.Abs, ev.Root
+	}
+	run.liveMux.Lock()
+	if run.RunCount > 1 {
+		lastExit = strconv.Itoa(run.LastExit)
+	}
+	if run.LastTimedOut {
+		lastTimedOut = "1"
+	}
+	run.liveMux.Unlock()
+
+	return append(os.Environ(),
+		envEventOp+"="+op,
+		envEventPath+"="+path,
+		envWatchRoot+"="+root,
+		envRunCount+"="+strconv.Itoa(run.RunCount),
+		envLastExit+"="+lastExit,
+		envLastTimedOut+"="+lastTimedOut,
+		envChangedFiles+"="+changedFile)
+}
+
+// Writes the paths of `changed` to a new temporary file, one per line,
+// replacing that of the previous run. Returns the file's path, or nothing if
+// it couldn't be written.
+func (run *runDirective) writeChanged(changed []fsEvent) string {
+	run.removeChanged()
+
+	var list strings.Builder
+	for _, c := range changed {
+		list.WriteString(c.Abs)
+		list.WriteByte('\n')
+	}
+
+	f, e := ioutil.TempFile("", "runonchange-changed-")
+	if e == nil {
+		run.changedFile = f.Name()
+		_, e = f.WriteString(list.String())
+		if closeErr := f.Close(); e == nil {
+			e = closeErr
+		}
+	}
+	if e != nil {
+		fmt.Fprintf(os.Stderr,
+			"\t%s%s: listing changed files for %s: %s\n",
+			run.label(), color.New(color.Bold, color.FgBlue).Sprintf("warning"), envChangedFiles, e)
+		return ""
+	}
+	return run.changedFile
+}
+
+// Deletes the file writeChanged() last wrote, if any.
+func (run *runDirective) removeChanged() {
+	if len(run.changedFile) == 0 {
+		return
+	}
+	os.Remove(run.changedFile)
+	run.changedFile = ""
+}
+
+// Exit status reported for COMMANDs stopped for taking longer than -t, as
+// timeout(1) does.
+const exitTimedOut = 124
+
+// Exit status of COMMAND per error `e` from running it, as shells report it:
+// 128 plus the signal number for COMMANDs killed by a signal.
+func exitStatus(e error) int {
+	if e == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(e, &exitErr) {
+		return 127 // never started
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return exitErr.ExitCode()
+}
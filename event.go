@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Which form of an event's path FILE_PATTERNs are matched against.
+type matchSubject int
+
+const (
+	msRelative matchSubject = iota // relative to the DIR_TO_WATCH it's under
+	msBasename
+	msAbsolute
+)
+
+func (s matchSubject) String() string {
+	switch s {
+	case msRelative:
+		return "relative"
+	case msBasename:
+		return "basename"
+	case msAbsolute:
+		return "absolute"
+	}
+	panic(fmt.Sprintf("unexpected matchSubject, '%d'", int(s)))
+}
+
+// A filesystem event, along with each form of its path, so the rest of
+// runonchange needn't care how DIR_TO_WATCH happened to be typed.
+type fsEvent struct {
+	fsnotify.Event
+
+	Root string // absolute path of the watch root the event is under
+	Rel  string // slash-separated path relative to Root
+	Abs  string // cleaned absolute path
+}
+
+func (run *runDirective) normalizeEvent(e fsnotify.Event) fsEvent {
+	ev := fsEvent{Event: e, Abs: filepath.Clean(e.Name)}
+	if abs, err := filepath.Abs(e.Name); err == nil {
+		ev.Abs = abs
+	}
+
+	for _, r := range run.rootDirs {
+		if isPathUnder(ev.Abs, r) && len(r) > len(ev.Root) {
+			ev.Root = r
+		}
+	}
+
+	ev.Rel = filepath.ToSlash(ev.Abs)
+	if ev.Root != "" {
+		if rel, err := filepath.Rel(ev.Root, ev.Abs); err == nil {
+			ev.Rel = filepath.ToSlash(rel)
+		}
+	}
+	return ev
+}
+
+// The form of this event's path FILE_PATTERNs should be matched against.
+func (e fsEvent) subject(s matchSubject) string {
+	switch s {
+	case msBasename:
+		return filepath.Base(e.Abs)
+	case msAbsolute:
+		return e.Abs
+	}
+	return e.Rel
+}
+
+// Whether absolute, cleaned `path` is `dir` itself or somewhere beneath it.
+func isPathUnder(path, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,153 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Filesystems known not to deliver inotify events for (at least some) changes,
+// keyed by statfs(2) f_type; see statfs(2) and linux/magic.h
+var remoteFSMagic = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+	0x73757245: "coda",
+	0x6b414653: "afs",
+	0x00c36400: "ceph",
+	0x786f4256: "vboxsf",
+}
+
+const overlayFSMagic uint32 = 0x794c7630
+
+// isOverlayOnRemote results, by device (ie: stat(2) st_dev, which each overlay
+// mount has its own of); containers commonly have their entire tree on
+// overlayfs, so we'd otherwise re-read mountinfo for every directory.
+var (
+	overlayVerdicts    = make(map[uint64]bool)
+	overlayVerdictsMux sync.Mutex
+)
+
+// Whether `path` lives on a filesystem we can't expect kernel notifications
+// from, and if so the name of that filesystem.
+func needsPolling(path string) (bool, string, error) {
+	fsType, e := statfsType(path)
+	if e != nil {
+		return false, "", e
+	}
+	if name, ok := remoteFSMagic[fsType]; ok {
+		return true, name, nil
+	}
+	if fsType == overlayFSMagic && isOverlayOnRemote(path) {
+		return true, "overlay", nil
+	}
+	return false, "", nil
+}
+
+func statfsType(path string) (uint32, error) {
+	var stat syscall.Statfs_t
+	if e := syscall.Statfs(path, &stat); e != nil {
+		return 0, e
+	}
+	return uint32(stat.Type), nil
+}
+
+// Whether any layer of the overlay mount holding `path` is itself a remote
+// filesystem, per /proc/self/mountinfo. Failures to find out are a "no".
+func isOverlayOnRemote(path string) bool {
+	abs, e := filepath.Abs(path)
+	if e != nil {
+		return false
+	}
+	var stat syscall.Stat_t
+	if e := syscall.Stat(abs, &stat); e != nil {
+		return false
+	}
+	dev := uint64(stat.Dev)
+
+	overlayVerdictsMux.Lock()
+	defer overlayVerdictsMux.Unlock()
+	if verdict, ok := overlayVerdicts[dev]; ok {
+		return verdict
+	}
+
+	f, e := os.Open("/proc/self/mountinfo")
+	if e != nil {
+		return false
+	}
+	defer f.Close()
+
+	// mountinfo lines look like:
+	//   36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - overlay overlay rw,lowerdir=...
+	var bestMount, bestOpts string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		halves := strings.SplitN(scanner.Text(), " - ", 2)
+		if len(halves) != 2 {
+			continue
+		}
+		mountFields, fsFields := strings.Fields(halves[0]), strings.Fields(halves[1])
+		if len(mountFields) < 5 || len(fsFields) < 3 {
+			continue
+		}
+
+		mount := unescapeMountinfo(mountFields[4])
+		if !isPathUnder(abs, mount) || len(mount) < len(bestMount) {
+			continue
+		}
+		bestMount = mount
+		bestOpts = ""
+		if fsFields[0] == "overlay" {
+			bestOpts = fsFields[2]
+		}
+	}
+
+	verdict := false
+	for _, opt := range strings.Split(bestOpts, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || (kv[0] != "lowerdir" && kv[0] != "upperdir") {
+			continue
+		}
+		for _, layer := range strings.Split(kv[1], ":") {
+			fsType, e := statfsType(layer)
+			if e != nil {
+				continue
+			}
+			if _, ok := remoteFSMagic[fsType]; ok {
+				verdict = true
+			}
+		}
+	}
+	overlayVerdicts[dev] = verdict
+	return verdict
+}
+
+// Undoes mountinfo's octal escaping of whitespace and backslashes (eg: "\040").
+func unescapeMountinfo(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if c, e := strconv.ParseUint(field[i+1:i+4], 8, 8); e == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,29 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "testing"
+
+func TestUnescapeMountinfo(t *testing.T) {
+	tests := []struct {
+		field, want string
+	}{
+		{"/", "/"},
+		{"/mnt/data", "/mnt/data"},
+		{`/mnt/my\040disk`, "/mnt/my disk"},
+		{`/mnt/tab\011here`, "/mnt/tab\there"},
+		{`/mnt/new\012line`, "/mnt/new\nline"},
+		{`/mnt/back\134slash`, `/mnt/back\slash`},
+		{`\040lead`, " lead"},
+		{`/a\040b\040c`, "/a b c"},
+		{`/not\08octal`, `/not\08octal`},
+		{`/short\04`, `/short\04`},
+		{`/trailing\`, `/trailing\`},
+	}
+	for _, tt := range tests {
+		if got := unescapeMountinfo(tt.field); got != tt.want {
+			t.Errorf("'%s': got %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// Filesystem detection is only implemented for linux; elsewhere we trust the
+// kernel to notify us, and -p remains available to force polling.
+func needsPolling(path string) (bool, string, error) {
+	return false, "", nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Translates shell glob `glob` into an (unanchored) regular expression, with
+// doublestar semantics for slash-separated paths. That is: "*" and "?" never
+// match a '/', character classes like "[ab]" may be negated as "[!ab]", and a
+// "**" path segment matches zero or more directories (eg: "**/x", "a/**/x",
+// "a/**", which matches "a" too). Backslash escapes the character following it,
+// within character classes too.
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			} else {
+				expr.WriteString(`\\`)
+			}
+		case '*':
+			isDouble := i+1 < len(glob) && glob[i+1] == '*'
+			startsSegment := i == 0 || glob[i-1] == '/'
+			if !isDouble || !startsSegment {
+				expr.WriteString(`[^/]*`)
+				for i+1 < len(glob) && glob[i+1] == '*' {
+					i++ // runs of '*' mid-segment are a plain '*'
+				}
+				continue
+			}
+
+			i++ // consume second '*'
+			switch {
+			case i+1 == len(glob) && i > 1:
+				// eg: "a/**" matches "a" itself too, so its '/' is optional
+				soFar := strings.TrimSuffix(expr.String(), "/")
+				expr.Reset()
+				expr.WriteString(soFar)
+				expr.WriteString(`(?:/.*)?`)
+			case i+1 == len(glob):
+				expr.WriteString(`.*`)
+			case glob[i+1] == '/':
+				i++ // consume the '/' too: it's optional when matching zero dirs
+				expr.WriteString(`(?:.*/)?`)
+			default:
+				expr.WriteString(`[^/]*`) // eg: "**foo" is just "*foo"
+			}
+		case '?':
+			expr.WriteString(`[^/]`)
+		case '[':
+			class, end := globClass(glob[i+1:])
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			expr.WriteString(class)
+			i += 1 + end
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return expr.String()
+}
+
+// Translates the character class `glob` starts with - just after its '[' -
+// into a regular expression's, returning it and the index in `glob` of the
+// class's closing ']'; -1 if it's never closed.
+func globClass(glob string) (string, int) {
+	var class strings.Builder
+	class.WriteByte('[')
+	i := 0
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		class.WriteByte('^')
+		i++
+	}
+	for start := i; i < len(glob); i++ {
+		c, escaped := glob[i], false
+		switch {
+		case c == ']' && i > start:
+			class.WriteByte(']')
+			return class.String(), i
+		case c == '\\' && i+1 < len(glob):
+			i++
+			c, escaped = glob[i], true
+		}
+
+		// Leaves ranges (eg: "a-z") be, but nothing else special to regexp.
+		if c == '\\' || c == '[' || c == ']' || c == '^' || (c == '-' && escaped) {
+			class.WriteByte('\\')
+		}
+		class.WriteByte(c)
+	}
+	return "", -1
+}
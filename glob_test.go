@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		matches []string
+		misses  []string
+	}{
+		{"*.go", []string{"a.go", ".go"}, []string{"a/b.go", "a.got"}},
+		{"a?c", []string{"abc", "a.c"}, []string{"a/c", "ac", "abbc"}},
+		{"a**b", []string{"ab", "axxb"}, []string{"a/b"}},
+		{"**", []string{"", "a", "a/b/c"}, nil},
+		{"**/x", []string{"x", "a/x", "a/b/x"}, []string{"ax", "a/xy"}},
+		{"a/**/x", []string{"a/x", "a/b/x", "a/b/c/x"}, []string{"ax", "b/x"}},
+		{"gen/**", []string{"gen", "gen/a", "gen/a/b"}, []string{"generated", "a/gen"}},
+		{"**foo", []string{"foo", "afoo"}, []string{"a/foo"}},
+		{"[ab].txt", []string{"a.txt", "b.txt"}, []string{"c.txt", "ab.txt"}},
+		{"[!ab].txt", []string{"c.txt"}, []string{"a.txt", "b.txt"}},
+		{"[^ab].txt", []string{"c.txt"}, []string{"a.txt"}},
+		{"[a-c]", []string{"a", "b", "c"}, []string{"d", "-"}},
+		{"[]a]", []string{"]", "a"}, []string{"b"}},
+		{"[!]a]", []string{"b"}, []string{"]", "a"}},
+		{`[\]]`, []string{"]"}, []string{`\`, "["}},
+		{`[\\]`, []string{`\`}, []string{"]"}},
+		{`[a\-c]`, []string{"a", "-", "c"}, []string{"b"}},
+		{"[[]", []string{"["}, []string{"]"}},
+		{"[x^]", []string{"x", "^"}, []string{"y"}},
+		{"[ab", []string{"[ab"}, []string{"a"}},
+		{`\*`, []string{"*"}, []string{"a"}},
+		{`a\`, []string{`a\`}, []string{"a"}},
+		{"a.b(c)", []string{"a.b(c)"}, []string{"axb(c)"}},
+	}
+	for _, tt := range tests {
+		expr := globToRegexp(tt.glob)
+		re, e := regexp.Compile("^" + expr + "$")
+		if e != nil {
+			t.Errorf("glob '%s': bad expression '%s': %v", tt.glob, expr, e)
+			continue
+		}
+		for _, path := range tt.matches {
+			if !re.MatchString(path) {
+				t.Errorf("glob '%s' (as '%s') should match '%s'", tt.glob, expr, path)
+			}
+		}
+		for _, path := range tt.misses {
+			if re.MatchString(path) {
+				t.Errorf("glob '%s' (as '%s') shouldn't match '%s'", tt.glob, expr, path)
+			}
+		}
+	}
+}
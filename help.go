@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -10,11 +12,38 @@ const version string = "v0.4.0"
 
 const defaultWaitTime time.Duration = 2 * time.Second
 
+const defaultPollInterval time.Duration = 1 * time.Second
+
+const defaultSettleMax time.Duration = 1 * time.Minute
+
+const defaultStopSignal syscall.Signal = syscall.SIGTERM
+
+const defaultKillAfter time.Duration = 5 * time.Second
+
+const defaultRestartBackoff time.Duration = 1 * time.Second
+
+const defaultRestartBackoffMax time.Duration = 30 * time.Second
+
+const defaultMaxRestarts int = 10
+
 func usage() string {
 	return fmt.Sprintf(
 		`Runs COMMAND everytime filesystem events happen under a DIR_TO_WATCH.
 
-  Usage:  COMMAND [-mqcdR] [-w WAIT_DURATION] [-i|-r FILE_PATTERN] [DIR_TO_WATCH, ...]
+  Usage:  COMMAND [-mqcdRpXIfbQ] [-w WAIT_DURATION] [-B DEBOUNCE_WINDOW]
+                  [-S [-u RESTART_BACKOFF] [-U BACKOFF_MAX] [-M MAX_RESTARTS]]
+                  [-C CLOBBER_WAIT] [-k SIGNAL] [-K KILL_AFTER] [-t RUN_TIMEOUT]
+                  [-l SETTLE_DURATION [-L SETTLE_MAX]]
+                  [-n TIMER_INTERVAL [-N]] [-P POLL_INTERVAL] [-x DIR_PATTERN]
+                  [-a [-j JOBS]] [-s SUBJECT] [-F VERDICT] [-e|-E OPS]
+                  [-i|-r|-G|-g FILE_PATTERN [-o|-O OPS]] [--shell SHELL]
+                  [--shell-template TEMPLATE] [--on-ready HOOK]
+                  [--ready-tcp|--ready-http|--ready-log|--ready-file PROBE]
+                  [DIR_TO_WATCH, ...]
+          [OPTIONS...] [DIR_TO_WATCH, ...] -- ARGV...
+          [OPTIONS...] --stage NAME [--needs STAGES] STAGE_COMMAND
+                  [FILE_PATTERN...] [--stage ...]... [DIR_TO_WATCH, ...]
+          [OPTIONS...] --rule NAME RULE... [--rule NAME RULE...]...
 
   Description:
    This program watches filesystem events under DIR_TO_WATCH. When an event
@@ -23,14 +52,47 @@ func usage() string {
 
    Generally all file system events under DIR_TO_WATCH (with exceptions as
    documented for -r and -i and -R) will trigger COMMAND. COMMAND will be run
-   in the current $SHELL.
+   in the current $SHELL (or that of --shell).
 
   Arguments:
+    COMMAND: a shell command line, handed to the shell however it accepts an
+    inline script (eg: "bash -c COMMAND", or "fish --no-config -c COMMAND").
+
+    ARGV: everything after a "--" is taken as the exact command to run, with no
+    shell involved (eg: "-- go test ./..."); COMMAND must then be omitted. This
+    works without $SHELL set.
+
+    COMMAND and ARGV may hold placeholders, replaced by paths of changed files
+    each time COMMAND is run:
+      {}    path of the file COMMAND is being run for (eg: "src/a.md")
+      {//}  its directory (eg: "src")
+      {/}   its basename (eg: "a.md")
+      {.}   its path without extension (eg: "src/a")
+      {/.}  its basename without extension (eg: "a")
+      {+}   paths of every file changed since COMMAND was last started
+    eg: "runonchange 'pandoc {} -o {.}.html' -g '**/*.md'". Paths are as seen
+    from the current directory (led by "./" where they'd start with "-", so
+    they're never taken as options), and are quoted for the shell as needed (so
+    placeholders shouldn't be quoted in COMMAND); under shells whose quoting
+    isn't handled (nu, xonsh, ion, ysh, and any only known by --shell-template)
+    use ARGV for placeholders instead. With ARGV, nothing is quoted and a "{+}"
+    argument becomes one argument per file. Runs not prompted by a filesystem
+    event (eg: at startup) are skipped if COMMAND uses any but {+}.
+    Wrap a placeholder in another pair of braces to pass it through literally,
+    eg: "{{}}" for "{}". Mind that includes a "{}" meant for another program,
+    as in "find . -exec gofmt -l {} +", which would otherwise be replaced (and
+    keep COMMAND from running at startup); runonchange notes any placeholders
+    it finds as it starts.
+
     DIR_TO_WATCH: indicates the directory whose ancestor file events should
     trigger COMMAND to be run. Defaults to the current working directory.
     Multiple directories can be passed, so DIR_TO_WATCH arguments must be the
     last on the commandline.
 
+    DIR_TO_WATCH may also be a regular file, in which case only events for that
+    file will trigger COMMAND. This survives editors that save by renaming a new
+    file over the original.
+
   General options:
     -d: indicates debugging output should be printed.
 
@@ -41,24 +103,219 @@ func usage() string {
     process, like an HTTP server, or perhaps a test suite that takes minutes to
     run.
 
-    -w WAIT_DURATION: indicates minimum seconds to wait after starting COMMAND,
+    -Q: queue a rerun for events received while COMMAND is still running, rather
+    than dropping them. Any number of such events collapse into one pending
+    rerun, which starts as soon as the current COMMAND exits. Has no effect with
+    -c, as then events don't wait for COMMAND to exit.
+
+    -a: run COMMAND once per file changed since it was last started, rather
+    than just once, with placeholders (eg: {}) and RUNONCHANGE_EVENT_* set for
+    each file in turn. Like xargs, this is one run as far as other options are
+    concerned (eg: -c clobbers all of them). Runs with no changed files (eg: at
+    startup) are skipped.
+
+    -j JOBS: with -a, how many COMMANDs may run at once. Defaults to the number
+    of CPUs.
+
+    -w WAIT_DURATION: indicates minimum time to wait after starting COMMAND,
     before re-running COMMAND again for new filesystem events. Defaults to %s.
 
+    -b: debounce rather than throttle. That is: rather than running COMMAND on
+    the first event and ignoring others for WAIT_DURATION, wait until no events
+    have arrived for DEBOUNCE_WINDOW and only then run COMMAND. This ensures the
+    final change of a burst (eg: saving several files in a row) is always seen
+    by COMMAND, at the cost of COMMAND starting a bit later.
+
+    -B DEBOUNCE_WINDOW: how long events must stop arriving for before COMMAND is
+    run; implies -b. Defaults to WAIT_DURATION.
+
+    -C CLOBBER_WAIT: with -c, the minimum time to wait after starting (or
+    finishing) COMMAND before clobbering it for new filesystem events. Defaults
+    to twice WAIT_DURATION.
+
+    -k SIGNAL: the signal sent to COMMAND's process group to stop it, whether
+    clobbering it (per -c) or shutting down (on SIGINT or SIGTERM); eg: "INT"
+    for servers that only clean up on ^C. SIGNAL is a number, or a name (with
+    or without a "SIG" prefix) among:
+      %s
+    Defaults to %s.
+
+    -K KILL_AFTER: how long COMMAND has to exit after being sent SIGNAL, after
+    which whatever's left of its process group is sent SIGKILL. Zero sends
+    SIGKILL straight away. Defaults to %s.
+
+    -t RUN_TIMEOUT: stop COMMAND (as -k and -K describe) should it still be
+    running RUN_TIMEOUT after it started, so a hung COMMAND can't hold up all
+    further runs. Such runs are reported as "timed out" rather than done, and
+    given an exit status of 124 (as with timeout(1)). By default COMMAND may
+    run for as long as it likes.
+
+    -S: supervise COMMAND, restarting it should it fail (ie: exit non-zero, or
+    be killed) on its own, rather than waiting for the next change; eg: for a
+    dev server run with -c. Restarts wait RESTART_BACKOFF, doubling with each
+    crash in a row up to BACKOFF_MAX, and stop after MAX_RESTARTS crashes in a
+    row until the next change. A crash loop is broken, and the backoff reset,
+    by any run not restarting a crash (eg: for a change), or by COMMAND staying
+    up for longer than BACKOFF_MAX. Only the first restart of a crash loop is
+    announced in full; crashes after it get a line each.
+
+    -u RESTART_BACKOFF: with -S, how long to wait before the first restart of a
+    crash loop. Defaults to %s.
+
+    -U BACKOFF_MAX: with -S, the longest to wait before a restart. Defaults to
+    %s.
+
+    -M MAX_RESTARTS: with -S, the most restarts in a row; zero means no limit.
+    Defaults to %d.
+
+    -l SETTLE_DURATION: before running COMMAND, wait for the files behind its
+    triggering events to settle. That is: until each is no longer open for
+    writing, or its size and modification time haven't changed for
+    SETTLE_DURATION. Useful when large files are copied or downloaded into
+    DIR_TO_WATCH, so COMMAND doesn't see them half-written. Events arriving
+    meanwhile are waited on in turn, once the current files settle.
+
+    -L SETTLE_MAX: with -l, the most time to wait for files to settle, after
+    which COMMAND runs anyway and any files still changing are reported.
+    Defaults to %s.
+
+    -n TIMER_INTERVAL: also run COMMAND every TIMER_INTERVAL, regardless of
+    filesystem events (eg: to regenerate a cache that goes stale on its own).
+    These runs are announced as "timer", and are otherwise treated just like
+    filesystem events: subject to -w, -c and -Q.
+
+    -N: with -n, restart the timer whenever COMMAND runs for filesystem events,
+    so TIMER_INTERVAL is counted from the last run of either kind.
+
+    All durations accept golang syntax (eg: "250ms", "1.5s", "2m"), while bare
+    numbers are taken to be seconds (eg: "2").
+
     -m: Disables the default behavior of ignoring some magic patterns you're
     likely not to want (if not passed, then this program runs as if "-i
     '%v'" was used).
 
+  Multiple rules:
+
+    --rule NAME: starts a rule, whose arguments are everything up to the next
+    --rule: a COMMAND (or -- ARGV) along with its own options, FILE_PATTERNs
+    and DIR_TO_WATCHs, just as runonchange would take them without --rule.
+    Each rule runs its COMMAND independently of the others, so that one process
+    can take the place of several runonchange instances, eg:
+
+      runonchange -R \
+        --rule css 'sass src/main.scss out/main.css' -g '**/*.scss' src \
+        --rule go -c 'go build ./... && ./server' -g '**/*.go' .
+
+    OPTIONS before the first --rule apply to every rule, as if passed first in
+    each. Rules share a single watcher, so -p and -P must be the same for all
+    rules, and directories watched by several rules are only watched once.
+    Output about each rule, and that of its COMMAND, is labelled with its NAME.
+
+  Stages:
+
+    --stage NAME: runs a STAGE_COMMAND (the argument following, taken like
+    COMMAND) as one step of a pipeline, in place of COMMAND. Stages run in the
+    order given, and at the same time unless ordered by --needs. FILE_PATTERNs
+    (and any -o/-O) following --stage are that stage's inputs, rather than the
+    rule's; eg:
+
+      runonchange \
+        --stage build 'go build ./...' -g '**/*.go' \
+        --stage lint --needs build 'golangci-lint run' \
+        --stage test --needs build 'go test ./...' -g '**/*.go' \
+        --stage serve --needs lint,test './server' .
+
+    --needs STAGES: a comma-separated list of earlier stages that must pass
+    before this --stage runs. A stage isn't run if any of these failed.
+
+    On a change, a stage only runs if a stage it needs ran, or one of the
+    changed files (as in RUNONCHANGE_CHANGED_FILES) is among its inputs. A
+    stage without FILE_PATTERNs has no inputs of its own, but takes any change
+    as input if it needs no other stage. Changes that are no stage's input are
+    ignored altogether. Every stage runs at startup and for -n. Once all stages
+    are done, a table of each stage's exit status and duration is printed, or
+    why it didn't run.
+
+  Readiness:
+
+    For COMMANDs that start servers (eg: with -c), these say when a run of
+    COMMAND is up and ready to use. Each run is then announced as "ready in"
+    however long it took, once all PROBEs pass; or as never ready if it exits
+    first. PROBEs are tried every %s, and may each be passed multiple times.
+
+    --ready-tcp PROBE: an address accepting TCP connections; either HOST:PORT
+    or just a PORT on localhost (eg: "8080").
+
+    --ready-http PROBE: a URL answering GET requests with a 2xx status; either
+    a full URL, or a PORT and path on localhost (eg: "8080/healthz").
+
+    --ready-log PROBE: a regular expression matching a line of COMMAND's
+    output, on stdout or stderr (eg: "listening on").
+
+    --ready-file PROBE: a path to a file written since the run started (eg: a
+    pid or socket file).
+
+    --on-ready HOOK: a shell command to run once COMMAND is ready (eg: to reload
+    the browser), with COMMAND's shell and environment. May be passed multiple
+    times. Hooks are run even with -- ARGV, and so then need a shell too.
+
+  Shell options:
+
+    --shell SHELL: run COMMAND with SHELL, a path or a name to look up in $PATH,
+    rather than with $SHELL.
+
+    Shells runonchange knows how to run COMMAND with are:
+      %s
+    Startup files are skipped wherever such a shell would otherwise read them
+    for an inline script (eg: fish's config.fish), so COMMAND runs the same in
+    any of them as it would with "sh -c".
+
+    --shell-template TEMPLATE: the arguments to run the shell with, for shells
+    not listed above, or to override how a listed one is run. TEMPLATE is split
+    on whitespace, and "{cmd}" is replaced by COMMAND; eg: for bash with your
+    aliases, "--shell-template '-i -c {cmd}'".
+
   Filesystem event configuration options:
 
     -R: indicates a recursive watch should be established under DIR_TO_WATCH.
     That is: COMMAND will be triggered by more than just file events of
-    immediate children to DIR_TO_WATCH.
+    immediate children to DIR_TO_WATCH. Directories created after startup are
+    watched as they appear, and watches of removed directories are dropped.
+
+    -x DIR_PATTERN: with -R, never watch directories whose name matches
+    DIR_PATTERN, nor anything beneath them. DIR_PATTERN is a shell glob as
+    accepted by https://golang.org/pkg/path/filepath/#Match and may be passed
+    multiple times. DIR_TO_WATCH arguments themselves are never excluded.
+
+    -X: Disables the default directory exclusions (if not passed, then this
+    program runs as if "-x '%s'" was used).
+
+    -p: poll the filesystem for changes everywhere, rather than relying on the
+    kernel to notify us (eg: inotify). Changes are detected by comparing each
+    file's modification time, size and inode between polls.
+
+    Without -p, polling is still used automatically for just those directories
+    living on filesystems known not to deliver notifications (NFS, CIFS/SMB,
+    FUSE mounts like sshfs, 9p, vboxsf, and overlays atop any of those). Polling
+    may still be needed for mounts we fail to detect, like Docker-for-Mac style
+    bind mounts.
+
+    -P POLL_INTERVAL: how long to wait between polls when polling (see -p).
+    Defaults to %s.
 
     File matching options:
 
     -i FILE_PATTERN: only run COMMAND if match is not made (invert/ignore)
     -r FILE_PATTERN: only run COMMAND if match is made
 
+    -I: honor ignore files, as git would: events for files they ignore are
+    dropped, and with -R ignored directories aren't watched at all. Ignore
+    files are read from every directory between DIR_TO_WATCH (or the root of
+    its git repository, if any) and the file in question; these are:
+      .git/info/exclude, then .gitignore, .ignore, and .rocignore
+    each taking precedence over those before it. Pass -d to see which ignore
+    file and line caused a file to be dropped.
+
     For both -i (ignore) and -r (restrict) the FILE_PATTERN value is a regular
     expression used to match against a file for which a filesystem events is
     has caused us to consider running COMMAND. To clarify:
@@ -71,11 +328,87 @@ func usage() string {
       Valid FILE_PATTERN strings are those accepted by:
         https://golang.org/pkg/regexp/#Compile
 
+    -G FILE_PATTERN: like -i, but FILE_PATTERN is a glob
+    -g FILE_PATTERN: like -r, but FILE_PATTERN is a glob
+
+    Globs are matched against the same path as regular expressions (see -s), so
+    by default are relative to DIR_TO_WATCH (eg: "-g '**/*.go'" or
+    "-G '**/testdata/**'"). Globs follow shell
+    conventions ("*", "?", "[abc]", "[!abc]", "\" to escape), except that "*"
+    and "?" never match a "/", while "**" as a whole path segment matches any
+    number of directories. Globs and regular expressions can be mixed freely;
+    they're all checked in the order they're passed.
+
+    -s SUBJECT: which form of a file's path FILE_PATTERNs are matched against;
+    SUBJECT is one of:
+      relative: the path relative to the DIR_TO_WATCH the file is under, eg:
+                "pkg/main.go" (the default)
+      basename: just the file's name, eg: "main.go"
+      absolute: the cleaned, absolute path, eg: "/home/me/src/pkg/main.go"
+    Either way, a file's path is the same regardless of how DIR_TO_WATCH was
+    typed (eg: "./", "src", or an absolute path).
+
+    -f: check FILE_PATTERNs as an ordered list of rules, like rsync filters,
+    rather than requiring every -r/-g to match and no -i/-G to match. Each -r
+    and -g is then a rule to include matching files, and each -i and -G a rule
+    to exclude them; the first rule to match a file decides its fate. For
+    example, to ignore everything under gen/ except protos directly in gen/api:
+      -f -g 'gen/api/*.proto' -G 'gen/**'
+
+    -F VERDICT: with -f, what to do with files no rule matches; VERDICT is
+    either "include" (the default) or "exclude".
+
     The easiest way to debug your patterns is to see the strings they're being
     matched against by simply doing a simple run but with -d flag passed, then
     touch(1) the file you're interested in events for, and you'll see debug
     printout of exactly what pattern matching occurs internally.
 
+    Operation filtering options:
+
+    -e OPS: only events for these operations can trigger COMMAND
+    -E OPS: events for these operations never trigger COMMAND
+
+    OPS is a comma-separated list of any of: create, write, remove, rename,
+    chmod. For example "-E chmod" keeps a formatter's permission fiddling from
+    triggering COMMAND. Both -e and -E may be passed multiple times.
+
+    -o OPS: limits the FILE_PATTERN immediately before it to just these
+    operations; other events are judged as if the pattern weren't passed
+    -O OPS: same as -o, but limits FILE_PATTERN to all but these operations
+
+    For example "-i '\.log$' -o write" ignores writes to log files, while still
+    running COMMAND when they're created or removed.
+
+  Environment for COMMAND:
+
+    COMMAND is run with these variables set, in addition to runonchange's own
+    environment:
+
+      RUNONCHANGE_EVENT_OP: operation of the filesystem event COMMAND is being
+      run for (eg: "WRITE"); empty if not run for one (eg: at startup, or -n).
+
+      RUNONCHANGE_EVENT_PATH: absolute path of that event's file.
+
+      RUNONCHANGE_WATCH_ROOT: absolute path of the DIR_TO_WATCH that file is
+      under.
+
+      RUNONCHANGE_RUN_COUNT: 1 for the first run of COMMAND, 2 for the next...
+
+      RUNONCHANGE_LAST_EXIT: exit status of the previous run of COMMAND (128
+      plus the signal number, if it was killed, or 124 if it timed out per -t);
+      empty for the first run.
+
+      RUNONCHANGE_LAST_TIMED_OUT: "1" if the previous run of COMMAND was
+      stopped for timing out per -t; empty otherwise.
+
+      RUNONCHANGE_CHANGED_FILES: path of a file listing the absolute path of
+      every file that changed since COMMAND was last started, one per line and
+      each only once. Useful for incremental rebuilds. Includes changes that
+      didn't get COMMAND run themselves (eg: for arriving within -w), so long
+      as they passed FILE_PATTERNs and such.
+
+      RUNONCHANGE_STAGE: NAME of the --stage being run, if any.
+
   Output while running:
 
     Generally the output strives to be self-explanatory and minimal. Minimal so
@@ -102,7 +435,18 @@ func usage() string {
       github.com/jzacsh/runonchange/releases/tag/%s
 `,
 		defaultWaitTime,
+		knownSignalNames(),
+		signalName(defaultStopSignal),
+		defaultKillAfter,
+		defaultRestartBackoff,
+		defaultRestartBackoffMax,
+		defaultMaxRestarts,
+		defaultSettleMax,
 		magicFileIgnoreRegexp,
+		probeInterval,
+		knownShellNames(),
+		strings.Join(defaultExcludeDirs, "' -x '"),
+		defaultPollInterval,
 		version,
 		version)
 }
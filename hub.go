@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Shares one watcher among every rule (see --rule), so directories watched by
+// several rules are only watched once. Each rule gets its own view of the
+// watcher, seeing events for just the directories it added, named as it added
+// them.
+type watchHub struct {
+	w watcher
+
+	mux   sync.Mutex
+	refs  map[string]int // number of views holding each absolute path
+	views []*watchView
+}
+
+func newWatchHub(w watcher) *watchHub {
+	h := &watchHub{w: w, refs: make(map[string]int)}
+	go h.forward()
+	return h
+}
+
+// A new view of the hub's watcher, for one rule.
+func (h *watchHub) view() *watchView {
+	v := &watchView{
+		hub:    h,
+		paths:  make(map[string]string),
+		events: make(chan fsnotify.Event),
+		queued: make(map[fsnotify.Event]bool),
+		wake:   make(chan struct{}, 1),
+	}
+	go v.deliver()
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.views = append(h.views, v)
+	return v
+}
+
+func (h *watchHub) forward() {
+	for e := range h.w.Events() {
+		h.mux.Lock()
+		views := append([]*watchView(nil), h.views...)
+		h.mux.Unlock()
+
+		for _, v := range views {
+			if name, ok := v.nameFor(e.Name); ok {
+				v.enqueue(fsnotify.Event{Name: name, Op: e.Op})
+			}
+		}
+	}
+}
+
+func (h *watchHub) Close() error { return h.w.Close() }
+
+// One rule's view of a watchHub; a watcher in its own right.
+type watchView struct {
+	hub    *watchHub
+	events chan fsnotify.Event
+
+	mux   sync.Mutex
+	paths map[string]string // absolute path -> path as added
+
+	// Events yet to be handed to the rule, oldest first, so a rule that's busy
+	// (eg: waiting out -K) never holds up the hub, nor any other rule.
+	queueMux sync.Mutex
+	queue    []fsnotify.Event
+	queued   map[fsnotify.Event]bool // set of queue
+	wake     chan struct{}           // signalled as queue gains events
+}
+
+// Queues `e` for the rule, unless an identical event is still waiting to be
+// handed over: that's all the rule needs to know, however many more there were
+// (eg: a checkout rewriting the same files over and over).
+func (v *watchView) enqueue(e fsnotify.Event) {
+	v.queueMux.Lock()
+	if !v.queued[e] {
+		v.queued[e] = true
+		v.queue = append(v.queue, e)
+	}
+	v.queueMux.Unlock()
+
+	select {
+	case v.wake <- struct{}{}:
+	default: // already due to be woken
+	}
+}
+
+// Hands queued events to the rule, as fast as it takes them.
+func (v *watchView) deliver() {
+	for range v.wake {
+		for {
+			v.queueMux.Lock()
+			if len(v.queue) == 0 {
+				v.queueMux.Unlock()
+				break
+			}
+			e := v.queue[0]
+			v.queue = v.queue[1:]
+			delete(v.queued, e)
+			v.queueMux.Unlock()
+
+			v.events <- e
+		}
+	}
+}
+
+func (v *watchView) Events() <-chan fsnotify.Event { return v.events }
+func (v *watchView) Errors() <-chan error          { return v.hub.w.Errors() }
+
+// Closing is left to whoever owns the hub.
+func (v *watchView) Close() error { return nil }
+
+func (v *watchView) Add(path string) error {
+	abs, e := filepath.Abs(path)
+	if e != nil {
+		return e
+	}
+
+	// Always passed on, as the watch may have since been dropped from under
+	// us (eg: the directory was deleted and recreated).
+	if e := v.hub.w.Add(abs); e != nil {
+		return e
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if _, ok := v.paths[abs]; ok {
+		return nil
+	}
+	v.paths[abs] = filepath.Clean(path)
+
+	v.hub.mux.Lock()
+	defer v.hub.mux.Unlock()
+	v.hub.refs[abs]++
+	return nil
+}
+
+func (v *watchView) Remove(path string) error {
+	abs, e := filepath.Abs(path)
+	if e != nil {
+		return e
+	}
+
+	v.mux.Lock()
+	_, ok := v.paths[abs]
+	delete(v.paths, abs)
+	v.mux.Unlock()
+	if !ok {
+		return fmt.Errorf("can't remove non-existent watch: %s", path)
+	}
+
+	v.hub.mux.Lock()
+	v.hub.refs[abs]--
+	lastRef := v.hub.refs[abs] == 0
+	if lastRef {
+		delete(v.hub.refs, abs)
+	}
+	v.hub.mux.Unlock()
+
+	if !lastRef {
+		return nil // other rules still watch it
+	}
+	return v.hub.w.Remove(abs)
+}
+
+// `name`, of an event from the hub's watcher, as this view should see it: in
+// terms of the path it added. False if it's not for any path this view added.
+func (v *watchView) nameFor(name string) (string, bool) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if added, ok := v.paths[name]; ok {
+		return added, true
+	}
+	added, ok := v.paths[filepath.Dir(name)]
+	if !ok {
+		return "", false
+	}
+
+	sep := string(filepath.Separator)
+	if strings.HasSuffix(added, sep) {
+		sep = "" // eg: "/"
+	}
+	return added + sep + filepath.Base(name), true
+}
+
+// Number of this view's directories watched via each backend of a
+// hybridWatcher; zeros for other watchers.
+func (v *watchView) counts() (notified, polled int) {
+	h, ok := v.hub.w.(*hybridWatcher)
+	if !ok {
+		return
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	for abs := range v.paths {
+		if h.isPolled(abs) {
+			polled++
+		} else {
+			notified++
+		}
+	}
+	return
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Per-directory ignore files honored under flgIgnoreFiles, in increasing order
+// of precedence.
+var ignoreFileNames = []string{".gitignore", ".ignore", ".rocignore"}
+
+// A single line of an ignore file, per gitignore(5).
+type ignoreRule struct {
+	Source string // path of the file the rule came from
+	Line   int
+	Text   string // the line, as written
+
+	Base    string // absolute directory Expr is matched relative to
+	Expr    *regexp.Regexp
+	Negate  bool
+	DirOnly bool
+}
+
+func (r *ignoreRule) String() string {
+	return fmt.Sprintf("%s:%d '%s'", r.Source, r.Line, r.Text)
+}
+
+// Lazily loaded ignore files for every directory we've been asked about.
+type ignoreTree struct {
+	// Absolute paths of directories we're watching; no ignore files above
+	// these are consulted, unless they're inside a git repository.
+	roots []string
+
+	mux     sync.Mutex
+	dirs    map[string][]ignoreRule // keyed by absolute directory
+	gitDirs map[string]bool         // whether a directory holds a .git
+}
+
+func newIgnoreTree(roots []string) *ignoreTree {
+	t := &ignoreTree{
+		dirs:    make(map[string][]ignoreRule),
+		gitDirs: make(map[string]bool),
+	}
+	for _, r := range roots {
+		if abs, e := filepath.Abs(r); e == nil {
+			t.roots = append(t.roots, abs)
+		}
+	}
+	return t
+}
+
+// Finds the rule deciding that `path` should be ignored, including because one
+// of its parent directories is ignored; nil if the path isn't ignored.
+func (t *ignoreTree) match(path string, isDir bool) *ignoreRule {
+	abs, e := filepath.Abs(path)
+	if e != nil {
+		return nil
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	top := t.topFor(abs)
+	if top == "" {
+		return nil
+	}
+
+	// As with git, it's not possible to re-include a file if a parent
+	// directory of that file is excluded, so parents are checked first.
+	var rules []ignoreRule
+	ancestors := strings.Split(strings.TrimPrefix(abs, top), string(filepath.Separator))
+	current := top
+	for i, name := range ancestors {
+		if name == "" {
+			continue
+		}
+		rules = append(rules, t.rulesFor(current)...)
+		current = filepath.Join(current, name)
+
+		isLast := i == len(ancestors)-1
+		if r := decide(rules, current, isDir || !isLast); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// The last of `rules` to match `path`, if it's an exclusion.
+func decide(rules []ignoreRule, path string, isDir bool) *ignoreRule {
+	for i := len(rules) - 1; i >= 0; i-- {
+		r := &rules[i]
+		if r.DirOnly && !isDir {
+			continue
+		}
+		rel, e := filepath.Rel(r.Base, path)
+		if e != nil {
+			continue
+		}
+		if !r.Expr.MatchString(filepath.ToSlash(rel)) {
+			continue
+		}
+
+		if r.Negate {
+			return nil
+		}
+		return r
+	}
+	return nil
+}
+
+// The highest directory whose ignore files apply to `abs`: its enclosing git
+// repository, or failing that its watch root.
+func (t *ignoreTree) topFor(abs string) string {
+	var root string
+	for _, r := range t.roots {
+		if isPathUnder(abs, r) && len(r) > len(root) {
+			root = r
+		}
+	}
+	if root == "" {
+		return ""
+	}
+
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if t.hasGit(dir) {
+			return dir
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return root // not in a git repository
+		}
+	}
+}
+
+func (t *ignoreTree) hasGit(dir string) bool {
+	has, ok := t.gitDirs[dir]
+	if !ok {
+		_, e := os.Stat(filepath.Join(dir, ".git"))
+		has = e == nil
+		t.gitDirs[dir] = has
+	}
+	return has
+}
+
+// Rules from ignore files held directly by `dir`, in increasing precedence.
+func (t *ignoreTree) rulesFor(dir string) []ignoreRule {
+	if rules, ok := t.dirs[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	if t.hasGit(dir) {
+		rules = append(rules, parseIgnoreFile(
+			filepath.Join(dir, ".git", "info", "exclude"), dir)...)
+	}
+	for _, name := range ignoreFileNames {
+		rules = append(rules, parseIgnoreFile(filepath.Join(dir, name), dir)...)
+	}
+	t.dirs[dir] = rules
+	return rules
+}
+
+// Drops anything remembered about `dir`'s ignore files, eg: because one of them
+// was just edited.
+func (t *ignoreTree) forget(dir string) {
+	abs, e := filepath.Abs(dir)
+	if e != nil {
+		return
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.dirs, abs)
+	delete(t.gitDirs, abs)
+}
+
+// Parses gitignore(5) formatted file at `path`, whose patterns are relative to
+// `base`. Unreadable files (eg: non-existent) have no rules.
+func parseIgnoreFile(path, base string) []ignoreRule {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		r, ok := parseIgnoreLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		r.Source, r.Line, r.Base = path, line, base
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// Whether `path` names one of the ignore files we honor.
+func isIgnoreFile(path string) bool {
+	name := filepath.Base(path)
+	for _, n := range ignoreFileNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIgnoreLine(text string) (ignoreRule, bool) {
+	r := ignoreRule{Text: text}
+
+	p := strings.TrimSuffix(text, "\r")
+	if len(p) == 0 || p[0] == '#' {
+		return r, false
+	}
+
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(p, " ") && !strings.HasSuffix(p, `\ `) {
+		p = p[:len(p)-1]
+	}
+	if len(p) == 0 {
+		return r, false
+	}
+
+	if p[0] == '!' {
+		r.Negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, `\!`) || strings.HasPrefix(p, `\#`) {
+		p = p[1:]
+	}
+
+	if strings.HasSuffix(p, "/") {
+		r.DirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if len(p) == 0 {
+		return r, false
+	}
+
+	// A slash anywhere but the end anchors the pattern to its file's directory;
+	// otherwise it may match at any depth.
+	anchor := `(?:^|/)`
+	if strings.Contains(p, "/") {
+		anchor = `^`
+		p = strings.TrimPrefix(p, "/")
+	}
+
+	// Unlike globToRegexp's, a trailing "/**" only matches what's within a
+	// directory, not the directory itself; per gitignore(5).
+	within := ""
+	if strings.HasSuffix(p, "/**") {
+		p, within = strings.TrimSuffix(p, "/**"), `/.+`
+	}
+
+	expr, e := regexp.Compile(anchor + globToRegexp(p) + within + `$`)
+	if e != nil {
+		return r, false
+	}
+	r.Expr = expr
+	return r, true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIgnoreLine(t *testing.T) {
+	tests := []struct {
+		text    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+		matches []string
+		misses  []string
+	}{
+		{text: "", ok: false},
+		{text: "# a comment", ok: false},
+		{text: "   ", ok: false},
+		{text: "/", ok: false},
+		{text: "!", ok: false},
+		{text: "*.log", ok: true,
+			matches: []string{"a.log", "sub/a.log"}, misses: []string{"a.logs", "a.log/b"}},
+		{text: "*.log  ", ok: true, matches: []string{"a.log"}, misses: []string{"a.log  "}},
+		{text: `a\ `, ok: true, matches: []string{"a "}, misses: []string{"a"}},
+		{text: "a.txt\r", ok: true, matches: []string{"a.txt"}},
+		{text: "!keep.log", ok: true, negate: true, matches: []string{"keep.log", "sub/keep.log"}},
+		{text: `\!bang`, ok: true, matches: []string{"!bang"}, misses: []string{"bang"}},
+		{text: `\#hash`, ok: true, matches: []string{"#hash"}},
+		{text: "build/", ok: true, dirOnly: true,
+			matches: []string{"build", "sub/build"}, misses: []string{"build/a"}},
+		{text: "/top.txt", ok: true, matches: []string{"top.txt"}, misses: []string{"sub/top.txt"}},
+		{text: "docs/*.tmp", ok: true,
+			matches: []string{"docs/a.tmp"}, misses: []string{"sub/docs/a.tmp", "docs/sub/a.tmp"}},
+		{text: "**/cache", ok: true, matches: []string{"cache", "a/b/cache"}},
+		{text: "gen/**", ok: true,
+			matches: []string{"gen/a", "gen/a/b"}, misses: []string{"gen", "sub/gen/a"}},
+	}
+	for _, tt := range tests {
+		r, ok := parseIgnoreLine(tt.text)
+		if ok != tt.ok {
+			t.Errorf("'%s': got ok=%t, want %t", tt.text, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if r.Negate != tt.negate || r.DirOnly != tt.dirOnly {
+			t.Errorf("'%s': got negate=%t dirOnly=%t, want %t and %t",
+				tt.text, r.Negate, r.DirOnly, tt.negate, tt.dirOnly)
+		}
+		for _, path := range tt.matches {
+			if !r.Expr.MatchString(path) {
+				t.Errorf("'%s' (as '%s') should match '%s'", tt.text, r.Expr, path)
+			}
+		}
+		for _, path := range tt.misses {
+			if r.Expr.MatchString(path) {
+				t.Errorf("'%s' (as '%s') shouldn't match '%s'", tt.text, r.Expr, path)
+			}
+		}
+	}
+}
+
+func TestIgnoreTreeMatch(t *testing.T) {
+	root, e := ioutil.TempDir("", "runonchange-ignore-")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		".git/HEAD": "", // so nothing above root is consulted
+		".gitignore": "*.log\n!keep.log\nbuild/\n!build/keep\n/top.txt\n" +
+			"docs/*.tmp\ngen/**\n",
+		"sub/.gitignore": "!b.log\n",
+		"sub/.ignore":    "local.txt\n",
+		"sub/.rocignore": "!local.txt\n",
+		"other/.ignore":  "*.txt\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+			t.Fatal(e)
+		}
+		if e := ioutil.WriteFile(path, []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"sub/deep/a.log", false, true},
+		{"keep.log", false, false},
+		{"sub/b.log", false, false}, // negated by sub/.gitignore
+		{"b.log", false, true},      // ... which doesn't apply up here
+
+		{"build", true, true},
+		{"build", false, false}, // only directories
+		{"build/out.o", false, true},
+		{"build/keep", false, true}, // can't re-include within an excluded dir
+
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false}, // anchored to root
+
+		{"docs/a.tmp", false, true},
+		{"docs/sub/a.tmp", false, false},
+
+		{"gen", true, false},
+		{"gen/a.go", false, true},
+		{"gen/sub", true, true},
+
+		{"sub/local.txt", false, false}, // .rocignore outranks .ignore
+		{"other/a.txt", false, true},
+		{"a.txt", false, false},
+	}
+	tree := newIgnoreTree([]string{root})
+	for _, tt := range tests {
+		r := tree.match(filepath.Join(root, tt.path), tt.isDir)
+		if ignored := r != nil; ignored != tt.ignored {
+			t.Errorf("'%s' (dir=%t): got ignored=%t (by %v), want %t",
+				tt.path, tt.isDir, ignored, r, tt.ignored)
+		}
+	}
+}
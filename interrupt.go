@@ -1,13 +1,21 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"syscall" // TODO(zacsh) important to use x/syscall/unix explicitly?
+	"time"
+
+	"github.com/fatih/color"
 )
 
+// How often to check whether a signalled process group has exited yet
+const stopPollInterval = 50 * time.Millisecond
+
 // Exit runonchange as gracefully as possible, cleaning up as we go.
-func (run *runDirective) gracefulCleanup(sig os.Signal) {
+func (rules *ruleSet) gracefulCleanup(sig os.Signal) {
 	fmt.Fprintf(os.Stderr,
 		"\nCaught %v (%d); starting graceful shutdown...\n", sig, sig)
 
@@ -25,40 +33,128 @@ func (run *runDirective) gracefulCleanup(sig os.Signal) {
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, " [graceful shutdown]: cleaning up `COMMAND`s...")
-	found, e := run.cleanupExtant(false /*wait*/)
-	fmt.Fprintf(os.Stderr, "%s\n", explainAttempt(e, !found /*wasNoop*/))
+	for _, run := range rules.Rules {
+		// Never released, so no new COMMANDs start while we're exiting
+		run.RunMux.Lock()
+		run.liveMux.Lock()
+		run.shuttingDown = true
+		run.liveMux.Unlock()
+
+		found, e := run.cleanupExtant(false /*wait*/, " [graceful shutdown]: ")
+		fmt.Fprintf(os.Stderr, " [graceful shutdown]: %scleaning up `COMMAND`s...%s\n",
+			run.label(), explainAttempt(e, !found /*wasNoop*/))
+		run.removeChanged()
+	}
 
 	fmt.Fprintf(os.Stderr, " [graceful shutdown]: cleaning up filesystem watchers...")
-	e = run.fsWatcher.Close()
+	e := rules.hub.Close()
 	fmt.Fprintf(os.Stderr, "%s\n", explainAttempt(e, false /*wasNoop*/))
 
 	os.Exit(exitStatus)
 }
 
-// Tries to kill any extant COMMAND invocations still running
+// Tries to stop any extant COMMAND invocations still running, per stopGroups,
+// reporting each step on a line of stderr starting with `prefix`.
 //
 // Returns an indication of whether attempt was made and its errors:
 //   true if any existed (ie: any cleanup was necessary)
 //   error if cleanup failed
-func (run *runDirective) cleanupExtant(wait bool) (existed bool, fail error) {
-	existed = run.Living != nil
+func (run *runDirective) cleanupExtant(wait bool, prefix string) (existed bool, fail error) {
+	existed = run.isRunning()
 	if !existed {
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, " PGID=%d... ", run.Living.Pid)
-	if fail = syscall.Kill(-run.Living.Pid, syscall.SIGKILL); fail != nil {
-		fmt.Fprintf(os.Stderr,
-			"failed to kill exec's pgroup[%d]: %s\n",
-			run.Living.Pid, fail)
+	if fail = run.stopGroups(run.haltExtant(), prefix); fail != nil {
 		return
 	}
 
 	if wait {
-		// TODO(zacsh) utilize os.Process.Wait() method
-		// eg:   s, e := run.Living.Wait()
 		<-run.Death
 	}
 	return
 }
+
+// Keeps the current run from starting any more COMMANDs, returning those it
+// already has for stopGroups. Callers must hold RunMux.
+func (run *runDirective) haltExtant() []*os.Process {
+	if run.halt != nil {
+		close(run.halt)
+		run.halt = nil
+	}
+	return run.living()
+}
+
+// Stops `cmd`, started just as its run was halted, as stopGroups would've
+// stopped it had it started a moment sooner; doesn't wait for it to exit.
+func (run *runDirective) stopLate(cmd *exec.Cmd) {
+	go func() {
+		if e := run.stopGroups([]*os.Process{cmd.Process}, "\t"); e != nil {
+			fmt.Fprintf(os.Stderr, "\t%s%s: stopping halted run: %s\n",
+				run.label(), color.New(color.Bold, color.FgBlue).Sprintf("warning"), e)
+		}
+	}()
+}
+
+// Sends StopSignal to the process group of each of `procs`, then waits up to
+// KillAfter for them to exit before sending SIGKILL to any still alive.
+func (run *runDirective) stopGroups(procs []*os.Process, prefix string) error {
+	report := func(pgid int, format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, "%s%sPGID=%d: %s\n",
+			prefix, run.label(), pgid, fmt.Sprintf(format, a...))
+	}
+
+	sig := run.StopSignal
+	if run.KillAfter == 0 {
+		sig = syscall.SIGKILL
+	}
+
+	var pending []int
+	for _, p := range procs {
+		if e := syscall.Kill(-p.Pid, sig); e != nil {
+			if errors.Is(e, syscall.ESRCH) {
+				report(p.Pid, "already exited")
+				continue
+			}
+			return fmt.Errorf("sending %s to exec's pgroup[%d]: %w", signalName(sig), p.Pid, e)
+		}
+		report(p.Pid, "sent %s", signalName(sig))
+		if sig != syscall.SIGKILL {
+			pending = append(pending, p.Pid)
+		}
+	}
+
+	start := time.Now()
+	for len(pending) > 0 {
+		var alive []int
+		for _, pgid := range pending {
+			if isGroupAlive(pgid) {
+				alive = append(alive, pgid)
+			} else {
+				report(pgid, "exited after %v", time.Since(start).Round(time.Millisecond))
+			}
+		}
+		pending = alive
+		if len(pending) == 0 {
+			break
+		}
+
+		if time.Since(start) >= run.KillAfter {
+			for _, pgid := range pending {
+				e := syscall.Kill(-pgid, syscall.SIGKILL)
+				if e != nil && !errors.Is(e, syscall.ESRCH) {
+					return fmt.Errorf("killing exec's pgroup[%d]: %w", pgid, e)
+				}
+				report(pgid, "still alive after %v; sent SIGKILL", run.KillAfter)
+			}
+			break
+		}
+		time.Sleep(stopPollInterval)
+	}
+	return nil
+}
+
+// Whether any process remains in process group `pgid`.
+func isGroupAlive(pgid int) bool {
+	return !errors.Is(syscall.Kill(-pgid, 0), syscall.ESRCH)
+}
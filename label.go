@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Prefix for output about this rule, per --rule NAME; empty for a lone,
+// unnamed rule.
+func (run *runDirective) label() string {
+	if len(run.Name) == 0 {
+		return ""
+	}
+	return color.MagentaString("[%s] ", run.Name)
+}
+
+// Where COMMAND's output should go, given it'd otherwise go to `w`: labelled
+// line by line when there are several rules' COMMANDs' output to tell apart.
+func (run *runDirective) outputTo(w io.Writer) io.Writer {
+	if len(run.Name) == 0 {
+		return w
+	}
+	return &prefixWriter{w: w, prefix: []byte(run.label())}
+}
+
+// Writes to `w`, starting each line with `prefix`.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+
+	mux     sync.Mutex
+	midLine bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var out bytes.Buffer
+	for rest := b; len(rest) > 0; {
+		if !p.midLine {
+			out.Write(p.prefix)
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		out.Write(line)
+		rest = rest[len(line):]
+		p.midLine = line[len(line)-1] != '\n'
+	}
+
+	if _, e := p.w.Write(out.Bytes()); e != nil {
+		return 0, e
+	}
+	return len(b), nil
+}
+
+// How long to keep passing along COMMAND's output after it's exited, should
+// something it left running (eg: a daemon) hold its output open.
+const outputDrainMax = 250 * time.Millisecond
+
+// Pipes of our own, standing in for those exec.Cmd would make for any of
+// COMMAND's Stdout or Stderr that aren't files. Otherwise cmd.Wait() waits on
+// those pipes' copying, so doesn't return until *every* process holding them
+// exits, rather than just COMMAND itself.
+type outputPipes struct {
+	writers []*os.File // COMMAND's ends, ours to close once it's started
+	copying sync.WaitGroup
+}
+
+// Has `cmd` write to outputPipes in place of any Stdout and Stderr that aren't
+// files, copying from them to the originals.
+func pipeOutput(cmd *exec.Cmd) (*outputPipes, error) {
+	pipes := &outputPipes{}
+	pipe := func(w io.Writer) (io.Writer, error) {
+		if _, isFile := w.(*os.File); isFile || w == nil {
+			return w, nil
+		}
+		r, pw, e := os.Pipe()
+		if e != nil {
+			return nil, e
+		}
+		pipes.writers = append(pipes.writers, pw)
+		pipes.copying.Add(1)
+		go func() {
+			defer pipes.copying.Done()
+			io.Copy(w, r)
+			r.Close()
+		}()
+		return pw, nil
+	}
+
+	var e error
+	if cmd.Stdout, e = pipe(cmd.Stdout); e != nil {
+		pipes.started()
+		return nil, e
+	}
+	if cmd.Stderr, e = pipe(cmd.Stderr); e != nil {
+		pipes.started()
+		return nil, e
+	}
+	return pipes, nil
+}
+
+// Closes our copies of COMMAND's ends of the pipes, once it's started (or
+// failed to), so they're closed once it - and whatever it left running - is.
+func (p *outputPipes) started() {
+	for _, w := range p.writers {
+		w.Close()
+	}
+	p.writers = nil
+}
+
+// Waits for what COMMAND wrote before exiting to be passed along, up to
+// outputDrainMax; copying carries on past that for as long as anything still
+// holds the pipes open.
+func (p *outputPipes) drain() {
+	drained := make(chan struct{})
+	go func() {
+		p.copying.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(outputDrainMax):
+	}
+}
@@ -5,10 +5,11 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 func main() {
-	run, perr := parseCli()
+	rules, perr := parseCli()
 	if perr != nil {
 		if errors.Is(perr, errHelpRequested) {
 			fmt.Printf(usage())
@@ -18,17 +19,19 @@ func main() {
 		die(exCommandline, perr)
 	}
 
-	if run.Features[flgDebugOutput] {
-		fmt.Fprintf(os.Stderr,
-			"[debug] here's what you asked for:\n%s\n",
-			run.debugStr())
+	for _, run := range rules.Rules {
+		if run.Features[flgDebugOutput] {
+			fmt.Fprintf(os.Stderr,
+				"[debug] %shere's what you asked for:\n%s\n",
+				run.label(), run.debugStr())
+		}
 	}
 
-	if e := run.setup(); e != nil {
+	signal.Notify(rules.Kills, os.Interrupt, syscall.SIGTERM)
+
+	if e := rules.setup(); e != nil {
 		die(exWatcher, e)
 	}
 
-	signal.Notify(run.Kills, os.Interrupt)
-
-	<-make(chan bool) // hang main
+	rules.gracefulCleanup(<-rules.Kills) // hang main until we're asked to exit
 }
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Whether any process has `path` open for writing, determined by trying to
+// take a read lease on it, which fcntl(2) refuses with EAGAIN in exactly that
+// case. Leases need us to own the file, so `known` is false when we couldn't
+// find out.
+func isOpenForWriting(path string) (open bool, known bool) {
+	f, e := os.Open(path)
+	if e != nil {
+		return false, false
+	}
+	defer f.Close()
+
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_FCNTL, f.Fd(), syscall.F_SETLEASE, syscall.F_RDLCK)
+	switch errno {
+	case 0:
+		syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_SETLEASE, syscall.F_UNLCK)
+		return false, true
+	case syscall.EAGAIN:
+		return true, true
+	}
+	return false, false
+}
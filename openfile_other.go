@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// Only implemented for linux; elsewhere settling relies on stat(2) alone.
+func isOpenForWriting(path string) (open bool, known bool) {
+	return false, false
+}
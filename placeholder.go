@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Placeholders COMMAND (or ARGV) may hold, replaced by changed files' paths
+// when it's run. A placeholder wrapped in another pair of braces is left as
+// the placeholder itself, eg: "{{}}" is a literal "{}".
+const (
+	phPath      = "{}"   // path of the triggering file
+	phDir       = "{//}" // its directory
+	phBase      = "{/}"  // its basename
+	phNoExt     = "{.}"  // its path without extension
+	phBaseNoExt = "{/.}" // its basename without extension
+	phAll       = "{+}"  // paths of every file changed since the last run
+)
+
+var allPlaceholders = []string{phPath, phDir, phBase, phNoExt, phBaseNoExt, phAll}
+
+// A piece of COMMAND: either literal text or one of the placeholders.
+type cmdPart struct {
+	Text        string
+	Placeholder string
+}
+
+// Splits `cmd` at its placeholders.
+func parsePlaceholders(cmd string) []cmdPart {
+	var parts []cmdPart
+	var text strings.Builder
+	for i := 0; i < len(cmd); {
+		if cmd[i] != '{' {
+			text.WriteByte(cmd[i])
+			i++
+			continue
+		}
+
+		if ph, ok := placeholderAt(cmd[i+1:]); ok && strings.HasPrefix(cmd[i+1+len(ph):], "}") {
+			text.WriteString(ph) // escaped, eg: "{{}}"
+			i += len(ph) + 2
+			continue
+		}
+		if ph, ok := placeholderAt(cmd[i:]); ok {
+			if text.Len() > 0 {
+				parts = append(parts, cmdPart{Text: text.String()})
+				text.Reset()
+			}
+			parts = append(parts, cmdPart{Placeholder: ph})
+			i += len(ph)
+			continue
+		}
+		text.WriteByte(cmd[i])
+		i++
+	}
+	if text.Len() > 0 {
+		parts = append(parts, cmdPart{Text: text.String()})
+	}
+	return parts
+}
+
+// The placeholder `s` starts with, if any.
+func placeholderAt(s string) (string, bool) {
+	for _, ph := range allPlaceholders {
+		if strings.HasPrefix(s, ph) {
+			return ph, true
+		}
+	}
+	return "", false
+}
+
+// Whether `cmd` has any placeholders at all.
+func usesPlaceholders(cmd string) bool {
+	for _, p := range parsePlaceholders(cmd) {
+		if len(p.Placeholder) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether `cmd` has placeholders naming the triggering file (ie: any but {+}).
+func usesPathPlaceholder(cmd string) bool {
+	for _, p := range parsePlaceholders(cmd) {
+		if len(p.Placeholder) > 0 && p.Placeholder != phAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the placeholders in `cmd` per `path` (the triggering file's) and
+// `all` (every changed file's), passing each path through notOption() and then
+// `quote`.
+func expandPlaceholders(
+	cmd, path string, all []string, quote func(string) string) string {
+	var expanded strings.Builder
+	for _, p := range parsePlaceholders(cmd) {
+		switch p.Placeholder {
+		case "":
+			expanded.WriteString(p.Text)
+		case phAll:
+			quoted := make([]string, len(all))
+			for i, a := range all {
+				quoted[i] = quote(notOption(a))
+			}
+			expanded.WriteString(strings.Join(quoted, " "))
+		default:
+			expanded.WriteString(quote(notOption(pathPart(p.Placeholder, path))))
+		}
+	}
+	return expanded.String()
+}
+
+// Relative `path`, such that it can't be mistaken for an option: as find(1)
+// does, "./" leads any path that would start with a '-' (eg: "./-rf").
+func notOption(path string) string {
+	if strings.HasPrefix(path, "-") {
+		return "./" + path
+	}
+	return path
+}
+
+// The part of `path` that placeholder `ph` stands for.
+func pathPart(ph, path string) string {
+	switch ph {
+	case phDir:
+		return filepath.Dir(path)
+	case phBase:
+		return filepath.Base(path)
+	case phNoExt:
+		return strings.TrimSuffix(path, filepath.Ext(path))
+	case phBaseNoExt:
+		base := filepath.Base(path)
+		return strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return path
+}
+
+// Points out any placeholders COMMAND (or ARGV, or a --stage) holds, as a "{}"
+// meant for some other program (eg: find's -exec) is easily mistaken for one.
+func (run *runDirective) reportPlaceholders() {
+	args := append([]string{run.Command}, run.Argv...)
+	for _, s := range run.Stages {
+		args = append(args, s.Command)
+	}
+
+	var found []string
+	var needsFile bool
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		needsFile = needsFile || usesPathPlaceholder(arg)
+		for _, p := range parsePlaceholders(arg) {
+			if len(p.Placeholder) > 0 && !seen[p.Placeholder] {
+				seen[p.Placeholder] = true
+				found = append(found, p.Placeholder)
+			}
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	var skipping string
+	if needsFile && !run.Features[flgEachFile] {
+		skipping = ", so runs not prompted by a change (eg: at startup, or per -n) are skipped"
+	}
+	fmt.Fprintf(os.Stderr,
+		"\t%s%s: %s will be replaced by changed files' paths%s; write {%s} to pass \"%s\" through as-is\n",
+		run.label(), color.New(color.Bold, color.FgBlue).Sprintf("note"),
+		strings.Join(found, ", "), skipping, found[0], found[0])
+}
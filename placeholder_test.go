@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePlaceholders(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []cmdPart
+	}{
+		{"", nil},
+		{"make", []cmdPart{{Text: "make"}}},
+		{"cat {}", []cmdPart{{Text: "cat "}, {Placeholder: phPath}}},
+		{"{//}/{/.}.html", []cmdPart{
+			{Placeholder: phDir}, {Text: "/"}, {Placeholder: phBaseNoExt}, {Text: ".html"}}},
+		{"{.}{/}{+}", []cmdPart{
+			{Placeholder: phNoExt}, {Placeholder: phBase}, {Placeholder: phAll}}},
+		{"echo {{}}", []cmdPart{{Text: "echo {}"}}},
+		{"echo {{+}} {}", []cmdPart{{Text: "echo {+} "}, {Placeholder: phPath}}},
+		{"echo {{{}}}", []cmdPart{{Text: "echo {{}}"}}}, // '{', then an escaped "{}", then '}'
+		{"echo {{}", []cmdPart{{Text: "echo {"}, {Placeholder: phPath}}},
+		{"awk '{print $1}' {x} {", []cmdPart{{Text: "awk '{print $1}' {x} {"}}},
+	}
+	for _, tt := range tests {
+		if got := parsePlaceholders(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("'%s': got %+v, want %+v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestUsesPlaceholders(t *testing.T) {
+	tests := []struct {
+		cmd       string
+		any, path bool
+	}{
+		{"make", false, false},
+		{"echo {{}}", false, false},
+		{"echo {+}", true, false},
+		{"cat {}", true, true},
+		{"cp {} out/{/}", true, true},
+	}
+	for _, tt := range tests {
+		if got := usesPlaceholders(tt.cmd); got != tt.any {
+			t.Errorf("usesPlaceholders('%s') = %t, want %t", tt.cmd, got, tt.any)
+		}
+		if got := usesPathPlaceholder(tt.cmd); got != tt.path {
+			t.Errorf("usesPathPlaceholder('%s') = %t, want %t", tt.cmd, got, tt.path)
+		}
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	tests := []struct {
+		cmd   string
+		path  string
+		all   []string
+		quote func(string) string
+		want  string
+	}{
+		{"pandoc {} -o {.}.html", "src/a.md", nil, shellQuote, "pandoc src/a.md -o src/a.html"},
+		{"{//} {/} {/.}", "src/a.md", nil, shellQuote, "src a.md a"},
+		{"cat {+}", "", []string{"a", "b c"}, shellQuote, "cat a 'b c'"},
+		{"cat {+}", "", nil, shellQuote, "cat "},
+		{"echo {{}} {}", "a", nil, shellQuote, "echo {} a"},
+		{"cat {}", "my file", nil, shellQuote, "cat 'my file'"},
+		{"cat {}", "it's", nil, shellQuote, `cat 'it'\''s'`},
+		{"cat {}", `a"$b`, nil, shellQuote, `cat 'a"$b'`},
+		{"cat {}", "a\nb", nil, shellQuote, "cat 'a\nb'"},
+		{"rm {}", "-rf", nil, shellQuote, "rm ./-rf"},
+		{"rm {+}", "", []string{"-rf", "a"}, shellQuote, "rm ./-rf a"},
+		{"rm {/}", "dir/-rf", nil, shellQuote, "rm ./-rf"},
+		{"cat {}", "it's", nil, fishQuote, `cat 'it\'s'`},
+		{"cat {}", "a,b.txt", nil, pwshQuote, "cat 'a,b.txt'"},
+		{"cat {}", "it's", nil, noQuote, "cat it's"},
+	}
+	for _, tt := range tests {
+		got := expandPlaceholders(tt.cmd, tt.path, tt.all, tt.quote)
+		if got != tt.want {
+			t.Errorf("'%s' for '%s' %q: got %q, want %q", tt.cmd, tt.path, tt.all, got, tt.want)
+		}
+	}
+}
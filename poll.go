@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Stat-polling watcher for filesystems that never deliver kernel notifications
+// (eg: NFS, FUSE mounts like sshfs, vboxsf). Synthesizes events by diffing
+// directory listings every interval.
+type pollWatcher struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+
+	mux sync.Mutex
+	// Watched directories, each with its last-seen listing.
+	dirs map[string]map[string]pollStat
+}
+
+// What we remember of a file between polls; kept small as we hold one for every
+// file under every watched directory.
+type pollStat struct {
+	modTime int64 // nanoseconds since epoch
+	size    int64
+	ino     uint64
+	mode    os.FileMode
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	p := &pollWatcher{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		dirs:     make(map[string]map[string]pollStat),
+	}
+	go p.poll()
+	return p
+}
+
+func (p *pollWatcher) Events() <-chan fsnotify.Event { return p.events }
+func (p *pollWatcher) Errors() <-chan error          { return p.errors }
+
+func (p *pollWatcher) Add(path string) error {
+	path = filepath.Clean(path)
+	listing, e := scanDir(path)
+	if e != nil {
+		return e
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.dirs[path] = listing
+	return nil
+}
+
+func (p *pollWatcher) Remove(path string) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	delete(p.dirs, filepath.Clean(path))
+	return nil
+}
+
+func (p *pollWatcher) Close() error {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	return nil
+}
+
+func (p *pollWatcher) poll() {
+	for {
+		// Sleep *between* scans rather than on a fixed ticker, so huge trees on
+		// slow mounts can't have scans pile up behind one another.
+		select {
+		case <-p.done:
+			return
+		case <-time.After(p.interval):
+		}
+
+		p.mux.Lock()
+		dirs := make([]string, 0, len(p.dirs))
+		for d := range p.dirs {
+			dirs = append(dirs, d)
+		}
+		p.mux.Unlock()
+
+		for _, d := range dirs {
+			if !p.rescan(d) {
+				return
+			}
+		}
+	}
+}
+
+// Diffs directory `dir` against its last listing and emits the differences.
+// Returns false if the watcher was closed mid-way.
+func (p *pollWatcher) rescan(dir string) bool {
+	listing, e := scanDir(dir)
+
+	p.mux.Lock()
+	last, ok := p.dirs[dir]
+	if ok {
+		if e != nil {
+			delete(p.dirs, dir)
+		} else {
+			p.dirs[dir] = listing
+		}
+	}
+	p.mux.Unlock()
+	if !ok {
+		return true // removed while we were busy
+	}
+
+	if e != nil {
+		if os.IsNotExist(e) {
+			return p.emit(fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+		}
+		select {
+		case p.errors <- e:
+			return true
+		case <-p.done:
+			return false
+		}
+	}
+
+	for name, now := range listing {
+		was, existed := last[name]
+		var op fsnotify.Op
+		switch {
+		case !existed || was.ino != now.ino:
+			op = fsnotify.Create
+		case !now.mode.IsDir() && // a subdirectory's listing changing isn't news, per inotify
+			(was.modTime != now.modTime || was.size != now.size):
+			op = fsnotify.Write
+		case was.mode != now.mode:
+			op = fsnotify.Chmod
+		default:
+			continue
+		}
+		if !p.emit(fsnotify.Event{Name: filepath.Join(dir, name), Op: op}) {
+			return false
+		}
+	}
+	for name := range last {
+		if _, ok := listing[name]; ok {
+			continue
+		}
+		if !p.emit(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *pollWatcher) emit(e fsnotify.Event) bool {
+	select {
+	case p.events <- e:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+func scanDir(dir string) (map[string]pollStat, error) {
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+
+	listing := make(map[string]pollStat, len(entries))
+	for _, entry := range entries {
+		info, e := entry.Info()
+		if e != nil {
+			continue // deleted since ReadDir; next scan can report it
+		}
+
+		stat := pollStat{
+			modTime: info.ModTime().UnixNano(),
+			size:    info.Size(),
+			mode:    info.Mode(),
+		}
+		if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+			stat.ino = uint64(sys.Ino)
+		}
+		listing[entry.Name()] = stat
+	}
+	return listing, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// How often readiness probes are retried, and the most each may take.
+const (
+	probeInterval = 100 * time.Millisecond
+	probeTimeout  = 1 * time.Second
+)
+
+// Kind of readiness probe, per the --ready-* flag it was built from
+type probeKind int
+
+const (
+	pkTCP  probeKind = iota // --ready-tcp
+	pkHTTP                  // --ready-http
+	pkLog                   // --ready-log
+	pkFile                  // --ready-file
+)
+
+func (k probeKind) String() string {
+	switch k {
+	case pkTCP:
+		return "tcp"
+	case pkHTTP:
+		return "http"
+	case pkLog:
+		return "log"
+	case pkFile:
+		return "file"
+	}
+	panic(fmt.Sprintf("unexpected probeKind, '%d'", int(k)))
+}
+
+// A condition that must hold for a run of COMMAND to be considered ready.
+type probe struct {
+	Kind   probeKind
+	Target string         // address, URL, or path; as passed for pkLog
+	Expr   *regexp.Regexp // pkLog only
+}
+
+func (p probe) String() string {
+	return fmt.Sprintf("[%s]'%s'", p.Kind, p.Target)
+}
+
+// Parses the argument of --ready-`kind`. Addresses and URLs default to
+// localhost, so either may be given as just a port (eg: "8080"), and URLs as
+// a port and path (eg: "8080/healthz").
+func parseProbe(kind probeKind, value string) (probe, error) {
+	p := probe{Kind: kind, Target: value}
+	switch kind {
+	case pkTCP:
+		if _, e := strconv.Atoi(value); e == nil {
+			p.Target = "localhost:" + value
+		}
+		host, port, e := net.SplitHostPort(p.Target)
+		if e != nil {
+			return p, e
+		}
+		if len(host) == 0 {
+			p.Target = net.JoinHostPort("localhost", port)
+		}
+
+	case pkHTTP:
+		if !strings.Contains(value, "://") {
+			p.Target = "http://localhost:" + strings.TrimPrefix(value, ":")
+		}
+		u, e := url.Parse(p.Target)
+		if e != nil {
+			return p, e
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return p, fmt.Errorf("expected an http(s) URL or a port, but got '%s'", value)
+		}
+
+	case pkLog:
+		expr, e := regexp.Compile(value)
+		if e != nil {
+			return p, e
+		}
+		p.Expr = expr
+
+	case pkFile:
+		if len(strings.TrimSpace(value)) == 0 {
+			return p, fmt.Errorf("expected a non-empty path")
+		}
+	}
+	return p, nil
+}
+
+// Tracks a single run's readiness, per run.Probes.
+type readyWatch struct {
+	started time.Time
+	done    chan struct{} // closed once the run's over, ready or not
+	once    sync.Once
+
+	mux    sync.Mutex
+	logged []bool // per pkLog probe, by index in run.Probes
+}
+
+// Starts watching for the run begun at `started` to become ready, if there are
+// any probes to say so. Callers must hold RunMux.
+func (run *runDirective) watchReady(started time.Time) {
+	run.ready = nil
+	if len(run.Probes) == 0 {
+		return
+	}
+
+	w := &readyWatch{
+		started: started,
+		done:    make(chan struct{}),
+		logged:  make([]bool, len(run.Probes)),
+	}
+	run.ready = w
+	go run.awaitReady(w, run.commandEnv(nil /*ev*/, "" /*changedFile*/))
+}
+
+// Marks the run `w` watches as over.
+func (w *readyWatch) finish() {
+	w.once.Do(func() { close(w.done) })
+}
+
+// Notes that COMMAND output `line`, in case a pkLog probe is waiting on it.
+func (run *runDirective) sawLine(w *readyWatch, line []byte) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	for i, p := range run.Probes {
+		if p.Kind == pkLog && !w.logged[i] && p.Expr.Match(line) {
+			w.logged[i] = true
+		}
+	}
+}
+
+// Polls run.Probes until all pass, then announces the run as ready and runs
+// the --on-ready hooks with environment `env`; gives up should the run end
+// without them all having passed.
+func (run *runDirective) awaitReady(w *readyWatch, env []string) {
+	passed := make([]bool, len(run.Probes))
+	tick := time.NewTicker(probeInterval)
+	defer tick.Stop()
+	for over := false; ; {
+		ready := true
+		for i, p := range run.Probes {
+			if !passed[i] {
+				passed[i] = run.isProbePassing(w, i, p)
+			}
+			ready = ready && passed[i]
+		}
+		if ready {
+			break
+		}
+
+		if over {
+			var waiting []string
+			for i, p := range run.Probes {
+				if !passed[i] {
+					waiting = append(waiting, p.String())
+				}
+			}
+			fmt.Printf("\t%s%s: exited before %s\n",
+				run.label(), color.CyanString("never ready"), strings.Join(waiting, ", "))
+			return
+		}
+
+		select {
+		case <-w.done:
+			over = true // but probes may've passed since, eg: on its last line
+		case <-tick.C:
+		}
+	}
+
+	fmt.Printf("%s%s in %v.\n",
+		run.label(), color.GreenString("ready"), time.Since(w.started).Round(time.Millisecond))
+	for _, hook := range run.OnReady {
+		run.runHook(hook, env)
+	}
+}
+
+func (run *runDirective) isProbePassing(w *readyWatch, i int, p probe) bool {
+	switch p.Kind {
+	case pkTCP:
+		conn, e := net.DialTimeout("tcp", p.Target, probeTimeout)
+		if e != nil {
+			return false
+		}
+		conn.Close()
+		return true
+
+	case pkHTTP:
+		client := http.Client{Timeout: probeTimeout}
+		resp, e := client.Get(p.Target)
+		if e != nil {
+			return false
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode >= 200 && resp.StatusCode < 300
+
+	case pkLog:
+		w.mux.Lock()
+		defer w.mux.Unlock()
+		return w.logged[i]
+
+	case pkFile:
+		// Only a file written since the run started counts, not one a previous
+		// run left behind; allowing for filesystems that keep whole seconds.
+		info, e := os.Stat(p.Target)
+		return e == nil && !info.ModTime().Before(w.started.Truncate(time.Second))
+	}
+	panic(fmt.Sprintf("unexpected probeKind, '%d'", int(p.Kind)))
+}
+
+// Runs --on-ready `hook` with COMMAND's shell and environment `env`, as
+// captured when the run started, without waiting on it; it's left be should
+// COMMAND be clobbered meanwhile.
+func (run *runDirective) runHook(hook string, env []string) {
+	cmd := exec.Command(run.Shell, run.shellArgv(hook)...)
+	cmd.Env = env
+	cmd.Stdout = run.outputTo(os.Stdout)
+	cmd.Stderr = run.outputTo(os.Stderr)
+	pipes, e := pipeOutput(cmd)
+	if e == nil {
+		e = cmd.Start()
+		pipes.started()
+	}
+	if e != nil {
+		run.warnHook(hook, e)
+		return
+	}
+	go func() {
+		e := cmd.Wait()
+		pipes.drain()
+		if e != nil {
+			run.warnHook(hook, e)
+		}
+	}()
+}
+
+func (run *runDirective) warnHook(hook string, e error) {
+	fmt.Fprintf(os.Stderr, "\t%s%s: --on-ready `%s`: %s\n",
+		run.label(), color.New(color.Bold, color.FgBlue).Sprintf("warning"), hook, e)
+}
+
+// Passes writes to `w` along, handing each complete line to sawLine().
+type lineScanner struct {
+	w      io.Writer
+	onLine func(line []byte)
+
+	mux     sync.Mutex
+	partial []byte
+}
+
+func (s *lineScanner) Write(b []byte) (int, error) {
+	n, e := s.w.Write(b)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.partial = append(s.partial, b...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		s.onLine(s.partial[:i])
+		s.partial = s.partial[i+1:]
+	}
+	return n, e
+}
+
+// Where COMMAND's output should go, given it'd otherwise go to `w`: also
+// scanned for --ready-log probes, if there are any.
+func (run *runDirective) readyOutputTo(w io.Writer) io.Writer {
+	watch := run.ready
+	if watch == nil {
+		return w
+	}
+	for _, p := range run.Probes {
+		if p.Kind == pkLog {
+			return &lineScanner{
+				w:      w,
+				onLine: func(line []byte) { run.sawLine(watch, line) },
+			}
+		}
+	}
+	return w
+}
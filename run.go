@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"syscall" // TODO(zacsh) important to use x/syscall/unix explicitly?
 	"time"
 
@@ -17,9 +18,35 @@ import (
 // flgNoDefaultIgnorePattern flag.
 var magicFileIgnoreRegexp *regexp.Regexp = regexp.MustCompile(`^(\.\w.*sw[a-z]|4913)$`)
 
+// Every operation fsnotify reports
+const allOps = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename | fsnotify.Chmod
+
+// Kind of FILE_PATTERN a matcher was built from
+type matcherKind int
+
+const (
+	mkRegexp matcherKind = iota // -i, -r
+	mkGlob                      // -G, -g
+)
+
+func (k matcherKind) String() string {
+	switch k {
+	case mkRegexp:
+		return "regexp"
+	case mkGlob:
+		return "glob"
+	}
+	panic(fmt.Sprintf("unexpected matcherKind, '%d'", int(k)))
+}
+
 type matcher struct {
 	Expr     *regexp.Regexp
 	IsIgnore bool
+	Kind     matcherKind
+	Source   string // FILE_PATTERN as passed on the commandline
+
+	// Operations this matcher applies to; zero means any.
+	Ops fsnotify.Op
 }
 
 func (m matcher) String() string {
@@ -27,96 +54,282 @@ func (m matcher) String() string {
 	if m.IsIgnore {
 		status = "IGNOR"
 	}
-	return fmt.Sprintf("[%s]'%v'", status, m.Expr)
+
+	var ops string
+	if m.Ops != 0 {
+		ops = fmt.Sprintf("{%s}", m.Ops)
+	}
+	return fmt.Sprintf("[%s:%s]'%s'%s", status, m.Kind, m.Source, ops)
 }
 
+// Runs COMMAND on behalf of `reason` (eg: "startup"), unless it was run too
+// recently. `ev` is the filesystem event responsible, if any.
 func (run *runDirective) maybeRun(
-	event *fsnotify.Event, stdOut bool) (bool, error) {
+	reason string, ev *fsEvent, stdOut bool) (bool, error) {
 	run.RunMux.Lock()
 	defer run.RunMux.Unlock()
 
 	if run.isRecent() {
 		return false, nil
 	}
+	return run.startRun(reason, ev, stdOut)
+}
 
-	run.Birth = make(chan os.Process, 1)
-	run.Death = make(chan error, 1)
-	run.LastRun = time.Now()
-	run.LastFin = time.Time{}
+// Runs COMMAND on behalf of `reason` and `ev` (nil if not a filesystem event),
+// first clobbering any previous run still alive if flgClobberCommands. Callers
+// must hold RunMux.
+func (run *runDirective) startRun(
+	reason string, ev *fsEvent, stdOut bool) (bool, error) {
+	if run.lacksFiles(ev) {
+		if stdOut {
+			fmt.Printf("\t%s%s: %s, as COMMAND needs a changed file\n",
+				run.label(), color.CyanString("skipped"), reason)
+		}
+		return false, nil
+	}
 
 	if stdOut {
-		msg := fmt.Sprintf("startup")
-		if event != nil {
-			msg = fmt.Sprintf("%s on %s", event.Op, event.Name)
-		}
-		fmt.Printf("\n%s %s ...\n",
+		fmt.Printf("\n%s%s %s ...\n",
+			run.label(),
 			color.YellowString("handling"),
-			msg)
+			reason)
 	}
 
 	if run.Features[flgClobberCommands] {
 		// Try to actually clobber first, if needed (`_` signal)
-		if _, e := run.cleanupExtant(true /*wait*/); e != nil {
+		if _, e := run.cleanupExtant(true /*wait*/, "\t"); e != nil {
 			return false, fmt.Errorf("trying clobber of last run: %v", e)
 		}
 	}
-	run.runAsync(stdOut)
+
+	// Only once any previous run is over, as its reap() reads these
+	run.liveMux.Lock()
+	run.LastRun, run.LastFin = time.Now(), time.Time{}
+	run.liveMux.Unlock()
+
+	changed := run.takeChanged()
+	changedFile := run.writeChanged(changed)
+	run.RunCount++
+
+	run.liveMux.Lock()
+	run.Running, run.TimedOut = true, false
+	run.liveMux.Unlock()
+	run.Death = make(chan error, 1)
+	run.halt = make(chan struct{})
+	run.startTimeout(run.Death)
+	run.watchReady(run.LastRun)
+	switch {
+	case len(run.Stages) > 0:
+		run.runStages(stdOut, ev, changed, changedFile)
+	case run.Features[flgEachFile]:
+		run.runEach(stdOut, changed, changedFile)
+	default:
+		cmd, display := run.newCmd(run.Command, ev, changed, changedFile)
+		run.runAsync(stdOut, cmd, display)
+	}
 	return true, nil
 }
 
-func (run *runDirective) runAsync(stdOut bool) bool {
-	go run.execAsync(stdOut)
-	defer close(run.Birth)
-	select {
-	case p := <-run.Birth:
-		run.Living = &p
+// Whether COMMAND can't be run for `ev` (nil if not a filesystem event), for
+// lack of the changed files its placeholders or flgEachFile call for.
+func (run *runDirective) lacksFiles(ev *fsEvent) bool {
+	if run.Features[flgEachFile] {
+		return len(run.Changed) == 0
 	}
-	return true
+	if run.Argv != nil && run.Argv[0] == phAll && len(run.Changed) == 0 {
+		return true // there'd be no program to run
+	}
+	if ev != nil {
+		return false
+	}
+	args := append([]string{run.Command}, run.Argv...)
+	for _, s := range run.Stages {
+		args = append(args, s.Command)
+	}
+	for _, arg := range args {
+		if usesPathPlaceholder(arg) {
+			return true
+		}
+	}
+	return false
 }
 
-func (run *runDirective) execAsync(msgStdout bool) {
+// Shell `command` (COMMAND, or a --stage's), or else ARGV if given, ready to
+// run on behalf of `ev` (nil if not a filesystem event) given files `changed`
+// since the last run, and listed in `changedFile`. Also returns the command as
+// the user should see it, with placeholders expanded.
+func (run *runDirective) newCmd(command string,
+	ev *fsEvent, changed []fsEvent, changedFile string) (*exec.Cmd, string) {
+	var path string
+	if ev != nil {
+		path = filepath.Clean(ev.Name)
+	}
+	all := make([]string, len(changed))
+	for i, c := range changed {
+		all[i] = filepath.Clean(c.Name)
+	}
+
+	var cmd *exec.Cmd
+	var display string
+	if run.Argv != nil {
+		var argv []string
+		for _, arg := range run.Argv {
+			if arg == phAll {
+				for _, a := range all {
+					argv = append(argv, notOption(a)) // one argument per file
+				}
+				continue
+			}
+			argv = append(argv, expandPlaceholders(arg, path, all, noQuote))
+		}
+		cmd = exec.Command(argv[0], argv[1:]...)
+		display = argvStr(argv)
+	} else {
+		quote := quoterFor(run.Shell)
+		if quote == nil {
+			quote = noQuote // there's nothing to quote, per parseRule()
+		}
+		display = expandPlaceholders(command, path, all, quote)
+		cmd = exec.Command(run.Shell, run.shellArgv(display)...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = run.commandEnv(ev, changedFile)
+	cmd.Stdout = run.readyOutputTo(run.outputTo(os.Stdout))
+	cmd.Stderr = run.readyOutputTo(run.outputTo(os.Stderr))
+	return cmd, display
+}
+
+// Starts `cmd` without waiting on it; its exit is later reported on
+// run.Death.
+func (run *runDirective) runAsync(msgStdout bool, cmd *exec.Cmd, display string) {
 	if msgStdout {
-		fmt.Printf("\n%s\t: `%s`\n",
-			color.YellowString("running"),
-			color.HiRedString(run.Command))
+		run.messageRunning(display)
 	}
 
-	// TODO(zacsh) find out a shell-agnostic way to run comands (eg: *bash*
-	// specifically takes a "-c" flag)
-	run.Cmd = exec.Command(run.Shell, "-c", run.Command)
-	run.Cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	run.Cmd.Stdout = os.Stdout
-	run.Cmd.Stderr = os.Stderr
+	death := run.Death
+	if e := run.start(cmd); e != nil {
+		run.reap(msgStdout, death, e)
+		return
+	}
+	go func() { run.reap(msgStdout, death, run.wait(cmd)) }()
+}
+
+// Records that COMMAND exited with `e`, and reports it on `death`.
+func (run *runDirective) reap(msgStdout bool, death chan error, e error) {
+	if run.runTimer != nil {
+		run.runTimer.Stop()
+	}
+	if run.ready != nil {
+		run.ready.finish()
+	}
+	run.liveMux.Lock()
+	run.LastExit = exitStatus(e)
+	if run.TimedOut {
+		run.LastExit = exitTimedOut
+	}
+	run.LastTimedOut = run.TimedOut
+	run.LastFin = time.Now()
+	run.liveMux.Unlock()
 
-	go func() {
-		e := run.Cmd.Run()
-		run.Living = nil
-		run.LastFin = time.Now()
-		if msgStdout {
-			run.messageDeath(e)
+	if msgStdout {
+		run.messageDeath(e)
+		if len(run.Stages) > 0 {
+			run.messageStages()
 		}
-		run.Death <- e
-	}()
+	}
 
-	// TODO(zacsh) this worker is going to perform some needless thrashing;
-	// redesign things to get rid of this; in fact the majority of data in
-	// beahvior.go feel unnecessary (Birth, Death, Living, etc.)
-	go func() {
-		for {
-			if run.Cmd.Process != nil {
-				run.Birth <- *run.Cmd.Process
-				break
-			}
+	// Only now may startRun() begin another run: with this one's outcome
+	// recorded, and nothing more of it to report.
+	run.liveMux.Lock()
+	run.Running = false
+	run.liveMux.Unlock()
+	death <- e
+}
+
+// Starts `cmd`, tracking it among run.Living until wait() is done with it.
+func (run *runDirective) start(cmd *exec.Cmd) error {
+	run.Cmd = cmd
+	pipes, e := pipeOutput(cmd)
+	if e != nil {
+		return e
+	}
+	e = cmd.Start()
+	pipes.started()
+	if e != nil {
+		return e
+	}
+
+	run.liveMux.Lock()
+	defer run.liveMux.Unlock()
+	run.Living = append(run.Living, cmd.Process)
+	if run.outputs == nil {
+		run.outputs = make(map[*exec.Cmd]*outputPipes)
+	}
+	run.outputs[cmd] = pipes
+	return nil
+}
+
+// Waits on `cmd`, as started by start(): until it exits, not until whatever
+// else holds its output open does.
+func (run *runDirective) wait(cmd *exec.Cmd) error {
+	e := cmd.Wait()
+
+	run.liveMux.Lock()
+	pipes := run.outputs[cmd]
+	delete(run.outputs, cmd)
+	for i, p := range run.Living {
+		if p == cmd.Process {
+			run.Living = append(run.Living[:i], run.Living[i+1:]...)
+			break
 		}
-	}()
+	}
+	run.liveMux.Unlock()
+
+	pipes.drain()
+	return e
+}
+
+// A copy of run.Living, safe from start() and wait().
+func (run *runDirective) living() []*os.Process {
+	run.liveMux.Lock()
+	defer run.liveMux.Unlock()
+	return append([]*os.Process(nil), run.Living...)
+}
+
+// Whether COMMAND is still running, per run.Running, safe from reap().
+func (run *runDirective) isRunning() bool {
+	run.liveMux.Lock()
+	defer run.liveMux.Unlock()
+	return run.Running
+}
+
+// Whether the current run was stopped for RunTimeout, per run.TimedOut, safe
+// from startTimeout().
+func (run *runDirective) hasTimedOut() bool {
+	run.liveMux.Lock()
+	defer run.liveMux.Unlock()
+	return run.TimedOut
+}
+
+func (run *runDirective) messageRunning(display string) {
+	fmt.Printf("\n%s%s\t: `%s`\n",
+		run.label(),
+		color.YellowString("running"),
+		color.HiRedString(display))
 }
 
 func (run *runDirective) isRecent() bool {
+	if run.Features[flgDebounce] {
+		return false // handleFSEvents already waited for things to quiet down
+	}
+
 	since := run.WaitFor
 	if run.Features[flgClobberCommands] {
-		since *= 2
+		since = run.ClobberWait
 	}
 
+	run.liveMux.Lock()
+	defer run.liveMux.Unlock()
 	return time.Since(run.LastRun) <= since ||
 		time.Since(run.LastFin) <= since
 }
@@ -135,69 +348,368 @@ func (run *runDirective) messageDeath(e error) {
 			color.New(color.Bold, color.FgRed).Sprintf(e.Error()))
 	}
 
+	outcome := color.YellowString("done")
+	if run.hasTimedOut() {
+		outcome = color.New(color.Bold, color.FgRed).Sprintf("timed out")
+		maybeErr = "" // just how we stopped it
+	}
+
 	// Summarize death
-	fmt.Printf("%s%s in %v.%s\n",
+	fmt.Printf("%s%s%s in %v.%s\n",
 		maybeLn,
-		color.YellowString("done"),
+		run.label(),
+		outcome,
 		run.LastFin.Sub(run.LastRun),
 		maybeErr)
 }
 
+// Arranges for the run reporting on `death` to be stopped, as if clobbered,
+// should it still be running after RunTimeout. Callers must hold RunMux.
+func (run *runDirective) startTimeout(death chan error) {
+	if run.RunTimeout == 0 {
+		return
+	}
+	run.runTimer = time.AfterFunc(run.RunTimeout, func() {
+		// Only held long enough to mark the run as timed out: stopping it can
+		// take up to KillAfter, while every change waits on RunMux.
+		run.RunMux.Lock()
+		if run.Death != death || !run.isRunning() {
+			run.RunMux.Unlock()
+			return // finished just in time
+		}
+		run.liveMux.Lock()
+		run.TimedOut = true
+		run.liveMux.Unlock()
+		procs := run.haltExtant()
+		run.RunMux.Unlock()
+
+		fmt.Fprintf(os.Stderr, "\t%s%s: still running after %v; stopping it\n",
+			run.label(), color.New(color.Bold, color.FgRed).Sprintf("timeout"), run.RunTimeout)
+		if e := run.stopGroups(procs, "\t"); e != nil {
+			fmt.Fprintf(os.Stderr, "\t%s%s: stopping timed out run: %s\n",
+				run.label(), color.New(color.Bold, color.FgBlue).Sprintf("warning"), e)
+		}
+	})
+}
+
 // Watches for - and emits to `out` - any applicable filesystem events.
-func (run *runDirective) watchFSEvents(out chan fsnotify.Event) {
+func (run *runDirective) watchFSEvents(out chan fsEvent) {
 
 	for {
 		select {
-		case e := <-run.fsWatcher.Events:
+		case raw := <-run.fsWatcher.Events():
+			e := run.normalizeEvent(raw)
 			if run.Features[flgDebugOutput] {
-				fmt.Fprintf(os.Stderr, "[debug] [%s] %s\n", e.Op.String(), e.Name)
+				fmt.Fprintf(os.Stderr, "[debug] %s[%s] %s (%s: '%s')\n",
+					run.label(), e.Op.String(), e.Name, run.MatchSubject, e.subject(run.MatchSubject))
 			}
 
-			if !run.Features[flgNoDefaultIgnorePattern] {
-				if magicFileIgnoreRegexp.MatchString(filepath.Base(e.Name)) {
-					continue
-				}
+			if run.isFileTargetNoise(e) {
+				continue
 			}
 
-			if run.isRejected(run.Patterns, e) {
-				continue
+			if run.ignores != nil && isIgnoreFile(e.Name) {
+				run.ignores.forget(filepath.Dir(e.Name))
+			}
+
+			if run.isAccepted(e) {
+				out <- e
 			}
 
-			out <- e
-		case err := <-run.fsWatcher.Errors:
+			if run.Features[flgRecursiveWatch] {
+				run.trackDirChanges(e, out)
+			}
+		case err := <-run.fsWatcher.Errors():
 			die(exFsevent, err)
 		}
 	}
 }
 
+// Whether event `e` passes the operation filters, the default ignore pattern
+// and the FILE_PATTERNs.
+func (run *runDirective) isAccepted(e fsEvent) bool {
+	if run.isOpRejected(e) {
+		return false
+	}
+
+	if !run.Features[flgNoDefaultIgnorePattern] {
+		if magicFileIgnoreRegexp.MatchString(filepath.Base(e.Name)) {
+			return false
+		}
+	}
+
+	if run.ignores != nil {
+		info, err := os.Stat(e.Abs)
+		if run.isIgnoredByFile(e.Abs, err == nil && info.IsDir()) {
+			if !run.Features[flgDebugOutput] {
+				run.tick(tickDropPatternIgnore)
+			}
+			return false
+		}
+	}
+
+	if run.isRejected(run.Patterns, e) {
+		return false
+	}
+	return !run.isUnwantedByStages(e)
+}
+
+// Whether there are stages, but none would take `e` as an input.
+func (run *runDirective) isUnwantedByStages(e fsEvent) bool {
+	if len(run.Stages) == 0 || run.isAnyStageInput(e) {
+		return false
+	}
+
+	if run.Features[flgDebugOutput] {
+		fmt.Fprintf(os.Stderr, "[debug] %sno stage takes '%s'\n", run.label(), e.Name)
+	} else {
+		run.tick(tickDropPatternRestric)
+	}
+	return true
+}
+
 // Given applicable filesystem events on `in`, runs COMMAND (per --help) for
 // each if appropriate, and exits runonchange is shutting down.
-func (run *runDirective) handleFSEvents(in chan fsnotify.Event) {
+func (run *runDirective) handleFSEvents(in chan fsEvent) {
+	// Under flgDebounce: the events of the current burst, and when it'll have
+	// been quiet for long enough.
+	var (
+		burst   []fsEvent
+		quieted <-chan time.Time
+	)
+
+	// Under flgSettle: the batch of events waiting on its files to settle,
+	// events that arrived meanwhile, and where settle()'s outcome is reported.
+	var (
+		settling  []fsEvent
+		unsettled []fsEvent
+		settled   = make(chan bool)
+	)
+
+	// Under -n: fires every TimerInterval, which flgTimerReset restarts after
+	// each filesystem-triggered run.
+	var timer *time.Timer
+	var timerFired <-chan time.Time
+	if run.TimerInterval != 0 {
+		timer = time.NewTimer(run.TimerInterval)
+		timerFired = timer.C
+	}
+	ranForChanges := func() {
+		if timer == nil || !run.Features[flgTimerReset] {
+			return
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C: // drain a firing we're now skipping
+			default:
+			}
+		}
+		timer.Reset(run.TimerInterval)
+	}
+	// Under flgSupervise: when COMMAND's due to be restarted after crashing.
+	// Runs for any other reason end the crash loop, resetting its backoff.
+	var restartDue <-chan time.Time
+	ranAnew := func() {
+		run.Restarts, restartDue = 0, nil
+	}
+
+	handleFS := func(ev fsEvent) {
+		if run.handleEvent(ev) {
+			ranForChanges()
+			ranAnew()
+		}
+	}
+
+	// Start an initial run before we even get FS events. It's started here,
+	// like every other run, as only this goroutine may replace run.Death.
+	run.maybeRun("startup", nil /*ev*/, true /*msgStdout*/)
+
+	handle := func(evs []fsEvent) {
+		if !run.Features[flgSettle] {
+			handleFS(evs[len(evs)-1])
+			return
+		}
+		settling = evs
+		go func() { settled <- run.settle(evs) }()
+	}
+
 	for {
 		select {
-		case sig := <-run.Kills:
-			run.gracefulCleanup(sig) // Shutdown all of runonchange
-		case <-run.Death:
+		case e := <-run.Death:
+			hadChanges := len(run.Queued) > 0
+			if run.runQueued() {
+				if hadChanges {
+					ranForChanges()
+				}
+				ranAnew()
+				continue
+			}
+			if run.LastTimedOut {
+				// Stopped by us, so neither died on its own nor crashed
+				if run.Features[flgSupervise] {
+					fmt.Printf("\t%s%s: stopped for timing out, so not restarting until the next change\n",
+						run.label(), color.CyanString("supervise"))
+				}
+				continue
+			}
+			if run.Features[flgSupervise] && e != nil {
+				restartDue = run.crashed()
+				continue
+			}
 			if !run.Features[flgClobberCommands] {
 				continue
 			}
 			fmt.Fprintf(os.Stderr,
-				"\t%s: command died on its own\n",
-				color.New(color.Bold, color.FgBlue).Sprintf("warning"))
+				"\t%s%s: command died on its own\n",
+				run.label(), color.New(color.Bold, color.FgBlue).Sprintf("warning"))
 
 		case ev := <-in:
-			if run.Living != nil && !run.Features[flgClobberCommands] {
-				run.tick(tickDropStillRunning)
+			run.recordChange(ev)
+			if settling != nil {
+				unsettled = append(unsettled, ev)
+				run.tick(tickSettling)
+				continue
+			}
+			if !run.Features[flgDebounce] {
+				handle([]fsEvent{ev})
 				continue
 			}
 
-			ran, err := run.maybeRun(&ev, true /*msgStdout*/)
-			if !ran {
-				run.tick(tickClobberUnnecessary)
+			burst = append(burst, ev)
+			quieted = time.After(run.DebounceWindow) // restart the quiet window
+			run.tick(tickDebounced)
+
+		case <-quieted:
+			if run.Features[flgDebugOutput] {
+				fmt.Fprintf(os.Stderr,
+					"[debug] quiet for %s after %d event(s)\n", run.DebounceWindow, len(burst))
+			}
+			evs := burst
+			quieted, burst = nil, nil
+			handle(evs)
+
+		case done := <-settled:
+			last := settling[len(settling)-1]
+			settling = nil
+			if len(unsettled) > 0 {
+				last = unsettled[len(unsettled)-1]
+				if done {
+					// Files changed again while we waited; they need settling too.
+					evs := unsettled
+					unsettled = nil
+					handle(evs)
+					continue
+				}
+				unsettled = nil // already waited SETTLE_MAX on these
 			}
-			if err != nil {
-				run.tick(tickClobberFailed)
+			handleFS(last)
+
+		case <-timerFired:
+			timer.Reset(run.TimerInterval)
+			if run.handleTimer() {
+				ranAnew()
+			}
+
+		case <-restartDue:
+			restartDue = nil
+			run.restart()
+		}
+	}
+}
+
+func (run *runDirective) handleEvent(ev fsEvent) bool {
+	return run.handleTrigger(fmt.Sprintf("%s on %s", ev.Op, ev.Name), &ev)
+}
+
+// Runs COMMAND on behalf of the -n timer, as handleEvent would for a
+// filesystem event.
+func (run *runDirective) handleTimer() bool {
+	return run.handleTrigger("timer", nil /*ev*/)
+}
+
+// Runs COMMAND for `reason` if appropriate, or if it's still running queues
+// (per flgQueueRerun) or drops the trigger. `ev` is nil for triggers other
+// than filesystem events. Returns whether COMMAND was started.
+func (run *runDirective) handleTrigger(reason string, ev *fsEvent) bool {
+	if run.isRunning() && !run.Features[flgClobberCommands] {
+		if run.Features[flgQueueRerun] {
+			run.queue(reason, ev)
+		} else {
+			run.tick(tickDropStillRunning)
+		}
+		return false
+	}
+
+	ran, err := run.maybeRun(reason, ev, true /*msgStdout*/)
+	if !ran {
+		run.tick(tickClobberUnnecessary)
+	}
+	if err != nil {
+		run.tick(tickClobberFailed)
+	}
+	return ran
+}
+
+// Remembers `reason` to rerun COMMAND once the current run exits, per
+// flgQueueRerun. `ev` is nil for triggers other than filesystem events.
+func (run *runDirective) queue(reason string, ev *fsEvent) {
+	isPending := len(run.Queued) > 0 || run.QueuedTimer
+	if ev == nil {
+		run.QueuedTimer = true
+	} else {
+		for _, q := range run.Queued {
+			if q.Abs == ev.Abs {
+				run.tick(tickQueued)
+				return
 			}
 		}
+		run.Queued = append(run.Queued, *ev)
+	}
+
+	if isPending {
+		run.tick(tickQueued)
+		return
+	}
+	fmt.Printf("\t%s%s: rerun pending, for %s\n",
+		run.label(), color.CyanString("queued"), reason)
+}
+
+// Starts the rerun queued up while COMMAND was last running, if any.
+func (run *runDirective) runQueued() bool {
+	if len(run.Queued) == 0 && !run.QueuedTimer {
+		return false
+	}
+
+	const maxListed = 5
+	var names []string
+	for i, q := range run.Queued {
+		if i == maxListed {
+			names = append(names, fmt.Sprintf("(+%d more)", len(run.Queued)-maxListed))
+			break
+		}
+		names = append(names, q.Name)
 	}
+
+	var reasons []string
+	if run.QueuedTimer {
+		reasons = append(reasons, "timer")
+	}
+	if len(names) > 0 {
+		reasons = append(reasons, fmt.Sprintf("changes to %s", strings.Join(names, ", ")))
+	}
+
+	var last *fsEvent
+	if len(run.Queued) > 0 {
+		last = &run.Queued[len(run.Queued)-1]
+	}
+	run.Queued, run.QueuedTimer = nil, false
+
+	run.RunMux.Lock()
+	defer run.RunMux.Unlock()
+	if _, e := run.startRun(fmt.Sprintf(
+		"pending rerun, for %s", strings.Join(reasons, " and ")),
+		last, true /*stdOut*/); e != nil {
+		run.tick(tickClobberFailed)
+	}
+	return true
 }
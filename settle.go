@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// What settle() last saw of a file it's waiting on.
+type settleState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // when size or modTime last changed
+}
+
+// Blocks until every file named by `evs` has settled, per flgSettle: either no
+// process has it open for writing, or neither its size nor modification time
+// has changed for SettleFor. Gives up waiting after SettleMax, reporting any
+// files still changing. Returns whether every file settled in time.
+func (run *runDirective) settle(evs []fsEvent) bool {
+	start := time.Now()
+	pending := make(map[string]settleState)
+	for _, ev := range evs {
+		info, e := os.Stat(ev.Abs)
+		if e != nil || !info.Mode().IsRegular() {
+			continue // nothing to wait on
+		}
+		pending[ev.Abs] = settleState{info.Size(), info.ModTime(), start}
+	}
+
+	interval := run.SettleFor / 4
+	if interval > 250*time.Millisecond {
+		interval = 250 * time.Millisecond
+	}
+
+	for {
+		for path, last := range pending {
+			if open, known := isOpenForWriting(path); known && !open {
+				run.debugSettled(path, "closed for writing")
+				delete(pending, path)
+				continue
+			}
+
+			info, e := os.Stat(path)
+			if e != nil {
+				run.debugSettled(path, "gone")
+				delete(pending, path)
+				continue
+			}
+			if info.Size() != last.size || !info.ModTime().Equal(last.modTime) {
+				pending[path] = settleState{info.Size(), info.ModTime(), time.Now()}
+				continue
+			}
+			if time.Since(last.since) >= run.SettleFor {
+				run.debugSettled(path, fmt.Sprintf("unchanged for %s", run.SettleFor))
+				delete(pending, path)
+			}
+		}
+
+		if len(pending) == 0 {
+			return true
+		}
+		if time.Since(start) >= run.SettleMax {
+			for path, last := range pending {
+				fmt.Fprintf(os.Stderr,
+					"\t%s%s: %s still changing after %s (now %d bytes); running anyway\n",
+					run.label(), color.New(color.Bold, color.FgBlue).Sprintf("warning"),
+					path, run.SettleMax, last.size)
+			}
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (run *runDirective) debugSettled(path, why string) {
+	if run.Features[flgDebugOutput] {
+		fmt.Fprintf(os.Stderr, "[debug] settled: %s (%s)\n", path, why)
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"github.com/fsnotify/fsnotify"
+	"path/filepath"
 )
 
 // Entry point for application to start runonchange logic, once preferences and
@@ -12,31 +12,73 @@ import (
 // runonchange logic we need to setup:
 // - worker to watch and filter Filesystem events
 // - worker to handle filtered events and invoke COMMAND
-// - configuration of filesystem event library
+// - configuration of filesystem event library, or polling where that won't work
 // - kick off an initial, sample COMMAND invocation
-func (run *runDirective) setup() error {
-	watcher, e := fsnotify.NewWatcher()
-	if e != nil {
-		return fmt.Errorf("starting FS watchers: %v", e)
+// all of which is done for each rule, atop one watcher they share.
+func (rules *ruleSet) setup() error {
+	lead := rules.Rules[0] // parseCli ensures rules agree on how to watch
+
+	var w watcher
+	if lead.Features[flgPollingWatch] {
+		w = newPollWatcher(lead.PollInterval)
+	} else {
+		hybrid, e := newHybridWatcher(lead.PollInterval, rules.anyDebug())
+		if e != nil {
+			return fmt.Errorf("starting FS watchers: %v", e)
+		}
+		w = hybrid
 	}
-	run.fsWatcher = watcher
+	rules.hub = newWatchHub(w)
 
-	fsEvents := make(chan fsnotify.Event)
-	go func() {
-		run.watchFSEvents(fsEvents)
-	}()
-	go func() {
-		run.handleFSEvents(fsEvents)
-	}()
+	for _, run := range rules.Rules {
+		if e := run.setup(rules.hub.view()); e != nil {
+			return e
+		}
+	}
+	return nil
+}
 
+// Whether any rule asked for debugging output.
+func (rules *ruleSet) anyDebug() bool {
+	for _, run := range rules.Rules {
+		if run.Features[flgDebugOutput] {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets up a single rule, watching via `w`.
+func (run *runDirective) setup(w watcher) error {
+	run.fsWatcher = w
+	run.watchedDirs = make(map[string]bool)
+	run.watchedFiles = make(map[string]bool)
+	run.fileOnlyDirs = make(map[string]bool)
+	for _, r := range run.watchRoots() {
+		if abs, e := filepath.Abs(r); e == nil {
+			run.rootDirs = append(run.rootDirs, abs)
+		}
+	}
+	if run.Features[flgIgnoreFiles] {
+		run.ignores = newIgnoreTree(run.rootDirs)
+	}
+
+	// Register before any workers start, as watchFSEvents goes on to maintain
+	// the same set of watches (eg: new directories in flgRecursiveWatch mode).
 	dirCount, e := run.registerDirectoriesToWatch()
 	if e != nil {
 		return fmt.Errorf("registering FS watchers: %v", e)
 	}
 	run.reportEstablishedWatches(dirCount)
+	run.reportPlaceholders()
 
-	// Start an initial run before we even get FS events.
-	go run.maybeRun(nil /*event*/, true /*msgStdout*/)
+	fsEvents := make(chan fsEvent)
+	go func() {
+		run.watchFSEvents(fsEvents)
+	}()
+	go func() {
+		run.handleFSEvents(fsEvents)
+	}()
 
 	return nil
 }
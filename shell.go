@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Stands in for COMMAND among a shell's arguments.
+const shellCommandToken = "{cmd}"
+
+// How a shell we know of is asked to run an inline script: the arguments
+// preceding the script itself. Startup files are skipped where shells would
+// otherwise read them even non-interactively, so COMMAND runs much as it would
+// under `sh -c`.
+var knownShells = map[string][]string{
+	"sh":   {"-c"},
+	"bash": {"-c"},
+	"dash": {"-c"},
+	"ash":  {"-c"},
+	"zsh":  {"-c"},
+	"ksh":  {"-c"},
+	"mksh": {"-c"},
+	"yash": {"-c"},
+	"osh":  {"-c"},
+	"ysh":  {"-c"},
+	"rc":   {"-c"},
+	"ion":  {"-c"},
+
+	"busybox": {"sh", "-c"}, // busybox itself, rather than a link named "sh"
+	"csh":     {"-f", "-c"},
+	"tcsh":    {"-f", "-c"},
+	"fish":    {"--no-config", "-c"},
+	"nu":      {"--no-config-file", "-c"},
+	"xonsh":   {"--no-rc", "-c"},
+	"elvish":  {"-norc", "-c"},
+
+	"pwsh":       {"-NoProfile", "-NonInteractive", "-Command"},
+	"powershell": {"-NoProfile", "-NonInteractive", "-Command"},
+}
+
+// Every knownShells name, formatted for --help.
+func knownShellNames() string {
+	names := make([]string, 0, len(knownShells))
+	for name := range knownShells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list strings.Builder
+	lineLen := 0
+	for i, name := range names {
+		if i > 0 {
+			list.WriteString(",")
+			lineLen++
+			if lineLen+len(name) > 64 {
+				list.WriteString("\n      ")
+				lineLen = 0
+			} else {
+				list.WriteString(" ")
+				lineLen++
+			}
+		}
+		list.WriteString(name)
+		lineLen += len(name)
+	}
+	return list.String()
+}
+
+// Arguments to run COMMAND with `shell`, per knownShells; false if `shell`
+// isn't one we know.
+func shellArgsFor(shell string) ([]string, bool) {
+	name := strings.TrimSuffix(filepath.Base(shell), ".exe")
+	args, ok := knownShells[name]
+	if !ok {
+		return nil, false
+	}
+	return append(append([]string{}, args...), shellCommandToken), true
+}
+
+// Parses --shell-template TEMPLATE into arguments for the shell, at least one
+// of which must hold shellCommandToken.
+func parseShellTemplate(template string) ([]string, error) {
+	args := strings.Fields(template)
+	for _, a := range args {
+		if strings.Contains(a, shellCommandToken) {
+			return args, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s somewhere in template, '%s'", shellCommandToken, template)
+}
+
+// The arguments to run the shell with, to have it run `command`.
+func (run *runDirective) shellArgv(command string) []string {
+	argv := make([]string, len(run.ShellArgs))
+	for i, a := range run.ShellArgs {
+		argv[i] = strings.ReplaceAll(a, shellCommandToken, command)
+	}
+	return argv
+}
+
+// Quotes `word` for POSIX shells, if they wouldn't otherwise read it back as
+// the same word.
+func shellQuote(word string) string {
+	if len(word) > 0 && !strings.ContainsAny(word, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// Quotes `word` for fish, always: only backslash and single quote are special
+// within its single quotes.
+func fishQuote(word string) string {
+	word = strings.ReplaceAll(word, `\`, `\\`)
+	return "'" + strings.ReplaceAll(word, "'", `\'`) + "'"
+}
+
+// Quotes `word` for shells (eg: rc) whose single quotes hold anything but a
+// single quote, which is written twice.
+func doubledQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", "''") + "'"
+}
+
+// Quotes `word` for PowerShell, always, as even some unremarkable paths mean
+// something else bare (eg: "a,b.txt" is an array). Its typographic single
+// quotes close a quote too, so are doubled just the same.
+func pwshQuote(word string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('\'')
+	for _, r := range word {
+		switch r {
+		case '\'', '\u2018', '\u2019', '\u201a', '\u201b':
+			quoted.WriteRune(r)
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteByte('\'')
+	return quoted.String()
+}
+
+// How to quote paths in COMMAND for `shell`, per its name; nil for shells (eg:
+// nu) whose quoting we don't handle, which COMMAND can't hold placeholders for.
+func quoterFor(shell string) func(string) string {
+	switch strings.TrimSuffix(filepath.Base(shell), ".exe") {
+	case "sh", "bash", "dash", "ash", "zsh", "ksh", "mksh", "yash", "osh",
+		"busybox", "csh", "tcsh":
+		return shellQuote
+	case "fish":
+		return fishQuote
+	case "rc", "elvish":
+		return doubledQuote
+	case "pwsh", "powershell":
+		return pwshQuote
+	}
+	return nil
+}
+
+func noQuote(word string) string { return word }
+
+// `argv` as the user should see it, quoted as a shell would need.
+func argvStr(argv []string) string {
+	words := make([]string, len(argv))
+	for i, a := range argv {
+		words[i] = shellQuote(a)
+	}
+	return strings.Join(words, " ")
+}
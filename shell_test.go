@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestQuoters(t *testing.T) {
+	tests := []struct {
+		word                       string
+		posix, fish, doubled, pwsh string
+	}{
+		{"a.go", "a.go", "'a.go'", "'a.go'", "'a.go'"},
+		{"", "''", "''", "''", "''"},
+		{"my file", "'my file'", "'my file'", "'my file'", "'my file'"},
+		{"it's", `'it'\''s'`, `'it\'s'`, "'it''s'", "'it''s'"},
+		{`back\slash`, `'back\slash'`, `'back\\slash'`, `'back\slash'`, `'back\slash'`},
+		{`$HOME "x"`, `'$HOME "x"'`, `'$HOME "x"'`, `'$HOME "x"'`, `'$HOME "x"'`},
+		{"a\nb", "'a\nb'", "'a\nb'", "'a\nb'", "'a\nb'"},
+		{"a,b.txt", "a,b.txt", "'a,b.txt'", "'a,b.txt'", "'a,b.txt'"},
+		{"-rf", "-rf", "'-rf'", "'-rf'", "'-rf'"},
+		{"it’s", "it’s", "'it’s'", "'it’s'", "'it’’s'"},
+	}
+	for _, tt := range tests {
+		for _, q := range []struct {
+			name  string
+			quote func(string) string
+			want  string
+		}{
+			{"shellQuote", shellQuote, tt.posix},
+			{"fishQuote", fishQuote, tt.fish},
+			{"doubledQuote", doubledQuote, tt.doubled},
+			{"pwshQuote", pwshQuote, tt.pwsh},
+		} {
+			if got := q.quote(tt.word); got != q.want {
+				t.Errorf("%s(%q) = %q, want %q", q.name, tt.word, got, q.want)
+			}
+		}
+	}
+}
+
+// Quoted words should come back out of the shells themselves unchanged, for
+// any of those shells that are installed.
+func TestQuotersRoundTrip(t *testing.T) {
+	words := []string{
+		"a.go", "my file", "it's", `back\slash`, `$HOME "x" $(id) ;|&`, "a\nb", "*", "~",
+	}
+	for _, shell := range []string{"sh", "bash", "dash", "zsh", "fish"} {
+		path, e := exec.LookPath(shell)
+		if e != nil {
+			continue
+		}
+		quote := quoterFor(path)
+		args, _ := shellArgsFor(path)
+		for _, w := range words {
+			d := &runDirective{ShellArgs: args}
+			out, e := exec.Command(path, d.shellArgv("printf %s "+quote(w))...).Output()
+			if e != nil {
+				t.Errorf("%s: printing %q: %v", shell, w, e)
+				continue
+			}
+			if string(out) != w {
+				t.Errorf("%s: printed %q as %q", shell, w, out)
+			}
+		}
+	}
+}
+
+func TestQuoterFor(t *testing.T) {
+	for shell, want := range map[string]bool{
+		"/bin/sh": true, "/usr/bin/bash": true, "fish": true, "rc": true,
+		"pwsh.exe": true, "/usr/bin/nu": false, "xonsh": false, "ysh": false,
+		"/opt/unknown": false,
+	} {
+		if got := quoterFor(shell) != nil; got != want {
+			t.Errorf("quoterFor(%s) != nil is %t, want %t", shell, got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Signals -k accepts by name, keyed without their "SIG" prefix.
+var knownSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+}
+
+// Names of knownSignals, for help docs.
+func knownSignalNames() string {
+	var names []string
+	for name := range knownSignals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Parses a signal given by name, with or without its "SIG" prefix and in any
+// case (eg: "TERM", "sigint"), or by number (eg: "15").
+func parseSignal(value string) (syscall.Signal, error) {
+	if num, e := strconv.Atoi(value); e == nil {
+		if num < 1 || num > maxSignal {
+			return 0, fmt.Errorf("expected a signal number from 1 to %d, but got %d", maxSignal, num)
+		}
+		return syscall.Signal(num), nil
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "SIG")
+	if sig, ok := knownSignals[name]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf(
+		"unknown signal '%s'; expected a number, or one of: %s", value, knownSignalNames())
+}
+
+// `sig` as one would pass it to -k, eg: "SIGTERM".
+func signalName(sig syscall.Signal) string {
+	for name, known := range knownSignals {
+		if known == sig {
+			return "SIG" + name
+		}
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
@@ -0,0 +1,8 @@
+//go:build linux
+// +build linux
+
+package main
+
+// Highest signal number -k accepts: that of the last realtime signal, SIGRTMAX;
+// see signal(7).
+const maxSignal = 64
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// Highest signal number -k accepts: 31 for the BSDs and darwin alike, short of
+// any realtime signals; see signal(3).
+const maxSignal = 31
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// A named step of COMMAND, per --stage.
+type stage struct {
+	Name    string
+	Command string
+
+	// Stages that must pass before this one runs; always given before it.
+	Needs []string
+
+	// FILE_PATTERNs deciding which changes are this stage's inputs. Without
+	// any, a stage's only inputs are those it needs, or any change if it needs
+	// none.
+	Patterns []matcher
+}
+
+func (s *stage) String() string {
+	var needs string
+	if len(s.Needs) > 0 {
+		needs = fmt.Sprintf(" needs[%s]", strings.Join(s.Needs, ","))
+	}
+	return fmt.Sprintf("%s%s: \"%s\" %v", s.Name, needs, s.Command, s.Patterns)
+}
+
+// Where a stage got to in a given run.
+type stageState int
+
+const (
+	ssPending stageState = iota
+	ssRunning
+	ssPassed
+	ssFailed
+	ssSkipped // inputs unchanged
+	ssBlocked // a stage it needs failed, or the run was clobbered
+)
+
+type stageResult struct {
+	State    stageState
+	Err      error
+	Started  time.Time
+	Finished time.Time
+	Note     string // why it was skipped or blocked
+}
+
+// Runs run.Stages, each once all it needs have passed, and then only if its
+// inputs are among `changed` or a stage it needs ran; `ev` (nil if not a
+// filesystem event) being a run not prompted by changes, runs every stage.
+// Doesn't wait on them; once all are done the first failure (if any) is
+// reported on run.Death, with a summary of every stage in run.LastStages.
+func (run *runDirective) runStages(
+	msgStdout bool, ev *fsEvent, changed []fsEvent, changedFile string) {
+	death, halt := run.Death, run.halt
+
+	type finish struct {
+		index int
+		err   error
+	}
+	finished := make(chan finish)
+
+	go func() {
+		results := make([]stageResult, len(run.Stages))
+		var failure error
+		for running := 0; ; running-- {
+			for i, s := range run.Stages {
+				if results[i].State != ssPending {
+					continue
+				}
+				ready, blockedBy, upstreamRan := run.stageNeeds(s, results)
+				if !ready {
+					continue
+				}
+
+				r := &results[i]
+				select {
+				case <-halt:
+					r.State, r.Note = ssBlocked, "clobbered"
+					continue
+				default:
+				}
+				if len(blockedBy) > 0 {
+					r.State, r.Note = ssBlocked, fmt.Sprintf("%s failed", blockedBy)
+					continue
+				}
+				if ev != nil && !upstreamRan && !run.stageWants(s, changed) {
+					r.State, r.Note = ssSkipped, "inputs unchanged"
+					continue
+				}
+
+				cmd, display := run.newCmd(s.Command, ev, changed, changedFile)
+				cmd.Env = append(cmd.Env, envStage+"="+s.Name)
+				if msgStdout {
+					run.messageRunning(fmt.Sprintf("%s: %s", s.Name, display))
+				}
+
+				r.Started = time.Now()
+				if e := run.start(cmd); e != nil {
+					r.State, r.Err, r.Finished = ssFailed, e, time.Now()
+					if failure == nil {
+						failure = fmt.Errorf("stage %s: %w", s.Name, e)
+					}
+					continue
+				}
+				select {
+				case <-halt: // clobbered just as we started it
+					run.stopLate(cmd)
+				default:
+				}
+				r.State = ssRunning
+				running++
+				go func(i int) { finished <- finish{i, run.wait(cmd)} }(i)
+			}
+
+			if running == 0 {
+				break
+			}
+			f := <-finished
+			r := &results[f.index]
+			r.Err, r.Finished = f.err, time.Now()
+			r.State = ssPassed
+			if f.err != nil {
+				r.State = ssFailed
+				if failure == nil {
+					failure = fmt.Errorf("stage %s: %w", run.Stages[f.index].Name, f.err)
+				}
+			}
+		}
+
+		run.LastStages = results
+		run.reap(msgStdout, death, failure)
+	}()
+}
+
+// Whether all of stage `s` needs are done, per `results`; if so, which of them
+// (if any) failed, and whether any of them ran.
+func (run *runDirective) stageNeeds(
+	s *stage, results []stageResult) (ready bool, blockedBy string, upstreamRan bool) {
+	for _, name := range s.Needs {
+		r := results[run.stageIndex(name)]
+		switch r.State {
+		case ssPending, ssRunning:
+			return false, "", false
+		case ssFailed, ssBlocked:
+			blockedBy = name
+		case ssPassed:
+			upstreamRan = true
+		}
+	}
+	return true, blockedBy, upstreamRan
+}
+
+// Position of the stage named `name` in run.Stages; -1 if there's none.
+func (run *runDirective) stageIndex(name string) int {
+	for i, s := range run.Stages {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Whether any of `changed` is an input of stage `s`.
+func (run *runDirective) stageWants(s *stage, changed []fsEvent) bool {
+	for _, e := range changed {
+		if run.isStageInput(s, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (run *runDirective) isStageInput(s *stage, e fsEvent) bool {
+	if len(s.Patterns) == 0 {
+		return len(s.Needs) == 0
+	}
+	return !run.rejects(s.Patterns, e, false /*report*/)
+}
+
+// Whether any stage would take `e` as an input.
+func (run *runDirective) isAnyStageInput(e fsEvent) bool {
+	for _, s := range run.Stages {
+		if run.isStageInput(s, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tabulates how each of run.Stages fared in the last run.
+func (run *runDirective) messageStages() {
+	width := 0
+	for _, s := range run.Stages {
+		if len(s.Name) > width {
+			width = len(s.Name)
+		}
+	}
+
+	for i, s := range run.Stages {
+		r := run.LastStages[i]
+
+		var status, detail string
+		switch r.State {
+		case ssPassed:
+			status = color.GreenString("%-8s", "ok")
+		case ssFailed:
+			status = color.New(color.Bold, color.FgRed).Sprintf("%-8s",
+				fmt.Sprintf("exit %d", exitStatus(r.Err)))
+		case ssSkipped:
+			status, detail = color.CyanString("%-8s", "skipped"), r.Note
+		case ssBlocked:
+			status, detail = color.YellowString("%-8s", "blocked"), r.Note
+		}
+		if !r.Started.IsZero() {
+			detail = r.Finished.Sub(r.Started).String()
+		}
+
+		fmt.Printf("\t%s%-*s  %s %s\n", run.label(), width, s.Name, status, detail)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Under flgSupervise, handles COMMAND having failed on its own: returns when it
+// should next be restarted, per restart(); nil if it shouldn't be.
+func (run *runDirective) crashed() <-chan time.Time {
+	run.liveMux.Lock()
+	shuttingDown := run.shuttingDown
+	run.liveMux.Unlock()
+	if shuttingDown {
+		return nil // not on its own after all
+	}
+
+	// A run that stayed up for longer than the longest backoff wasn't part of
+	// any crash loop.
+	if run.LastFin.Sub(run.LastRun) > run.RestartBackoffMax {
+		run.Restarts = 0
+	}
+
+	status := fmt.Sprintf("with exit %d", run.LastExit)
+	if run.Restarts == 0 {
+		run.CrashedSince = run.LastRun
+	} else {
+		status = fmt.Sprintf("again %s after %v",
+			status, run.LastFin.Sub(run.LastRun).Round(time.Millisecond))
+	}
+
+	if run.MaxRestarts > 0 && run.Restarts >= run.MaxRestarts {
+		fmt.Printf("\t%s%s: crashed %s; crashed %d times in a row over %v, so giving up until the next change\n",
+			run.label(), color.CyanString("supervise"), status,
+			run.Restarts+1, run.LastFin.Sub(run.CrashedSince).Round(time.Millisecond))
+		return nil
+	}
+
+	run.Restarts++
+	delay := run.restartDelay()
+	fmt.Printf("\t%s%s: crashed %s; restarting in %v (%s)\n",
+		run.label(), color.CyanString("supervise"), status, delay, run.restartCount())
+	return time.After(delay)
+}
+
+// How long to wait before the current restart: RestartBackoff, doubled for
+// every restart before it in the crash loop, up to RestartBackoffMax.
+func (run *runDirective) restartDelay() time.Duration {
+	delay := run.RestartBackoff
+	for i := 1; i < run.Restarts && delay < run.RestartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > run.RestartBackoffMax {
+		delay = run.RestartBackoffMax
+	}
+	return delay
+}
+
+// eg: "3/10", or just "3" without a limit.
+func (run *runDirective) restartCount() string {
+	if run.MaxRestarts == 0 {
+		return fmt.Sprintf("%d", run.Restarts)
+	}
+	return fmt.Sprintf("%d/%d", run.Restarts, run.MaxRestarts)
+}
+
+// Restarts COMMAND after crashed(), unless something else already has. Only
+// the first restart of a crash loop is announced as runs usually are, so a
+// COMMAND crashing over and over just gets crashed()'s line each time.
+func (run *runDirective) restart() {
+	run.RunMux.Lock()
+	defer run.RunMux.Unlock()
+	if run.isRunning() {
+		return
+	}
+
+	if _, e := run.startRun(
+		fmt.Sprintf("restart %s after crash", run.restartCount()),
+		nil /*ev*/, run.Restarts == 1 /*stdOut*/); e != nil {
+		run.tick(tickClobberFailed)
+	}
+}
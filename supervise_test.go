@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartDelay(t *testing.T) {
+	tests := []struct {
+		backoff, max time.Duration
+		restarts     int
+		want         time.Duration
+	}{
+		{time.Second, 30 * time.Second, 1, time.Second},
+		{time.Second, 30 * time.Second, 2, 2 * time.Second},
+		{time.Second, 30 * time.Second, 3, 4 * time.Second},
+		{time.Second, 30 * time.Second, 5, 16 * time.Second},
+		{time.Second, 30 * time.Second, 6, 30 * time.Second},
+		{time.Second, 30 * time.Second, 1000, 30 * time.Second},
+		{100 * time.Millisecond, time.Second, 4, 800 * time.Millisecond},
+		{100 * time.Millisecond, time.Second, 5, time.Second},
+		{time.Minute, 30 * time.Second, 1, 30 * time.Second},
+		{0, 30 * time.Second, 3, 0},
+	}
+	for _, tt := range tests {
+		run := &runDirective{
+			RestartBackoff:    tt.backoff,
+			RestartBackoffMax: tt.max,
+			Restarts:          tt.restarts,
+		}
+		if got := run.restartDelay(); got != tt.want {
+			t.Errorf("-u %v -U %v, restart #%d: got %v, want %v",
+				tt.backoff, tt.max, tt.restarts, got, tt.want)
+		}
+	}
+}
+
+func TestRestartCount(t *testing.T) {
+	run := &runDirective{Restarts: 3}
+	if got := run.restartCount(); got != "3" {
+		t.Errorf("without -M: got '%s', want '3'", got)
+	}
+	run.MaxRestarts = 10
+	if got := run.restartCount(); got != "3/10" {
+		t.Errorf("with -M 10: got '%s', want '3/10'", got)
+	}
+}
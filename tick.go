@@ -9,9 +9,13 @@ type tickSignal string
 
 const (
 	// Received an applicable filesystem event, but previous COMMAND is still
-	// running (use -c to clobber previous commands).
+	// running (use -c to clobber previous commands, or -Q to queue a rerun).
 	tickDropStillRunning tickSignal = "_"
 
+	// Received an applicable filesystem event while previous COMMAND is still
+	// running, and added it to the rerun already pending (see -Q)
+	tickQueued = "+"
+
 	// Received an applicable filesystem event, considered handling it by first
 	// clobbering previously running COMMAND, but found none still alive or the
 	// last event was too recent.
@@ -22,11 +26,24 @@ const (
 	// unable to handle the event now.
 	tickClobberFailed = "e"
 
-	// Received filesystem event but originating file matched -i PATTERN
+	// Received filesystem event but originating file matched -i PATTERN (or a
+	// rule in an ignore file, per -I)
 	tickDropPatternIgnore = "i"
 
 	// Received filesystem event but originating file doesn't match -r PATTERN
 	tickDropPatternRestric = "r"
+
+	// Received an applicable filesystem event, but are waiting for events to
+	// quiet down before handling it (see -b)
+	tickDebounced = "."
+
+	// Received an applicable filesystem event while waiting on files from
+	// earlier events to settle; it'll be waited on next (see -l)
+	tickSettling = "~"
+
+	// Received filesystem event but its operation was filtered out by -e or -E
+	// OPS (eg: a chmod)
+	tickDropOp = "o"
 )
 
 func (t tickSignal) String() string {
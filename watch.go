@@ -3,25 +3,33 @@ package main
 import (
 	"fmt"
 	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// Directory names never worth watching recursively; controlled via
+// flgNoDefaultExcludeDirs flag.
+var defaultExcludeDirs = []string{
+	".git", ".hg", ".svn", "node_modules", "vendor", "bazel-*", "target",
+}
+
 func (run *runDirective) registerDirectoriesToWatch() (int, error) {
 	count := 0
-	recursiveWalkHandler := func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			return nil
+	var files []string
+	for _, t := range run.WatchTargets {
+		if info, e := os.Stat(t); e != nil {
+			return count, e
+		} else if !info.IsDir() {
+			files = append(files, t)
+			continue
 		}
 
-		count++
-		return run.fsWatcher.Add(path)
-	}
-
-	for _, t := range run.WatchTargets {
 		if run.Features[flgRecursiveWatch] {
-			if e := filepath.Walk(t, recursiveWalkHandler); e != nil {
+			added, e := run.watchTree(t, nil /*found*/)
+			count += added
+			if e != nil {
 				return count, e
 			}
 		} else {
@@ -30,14 +38,196 @@ func (run *runDirective) registerDirectoriesToWatch() (int, error) {
 			}
 
 			count++
-			if e := run.fsWatcher.Add(t); e != nil {
+			if e := run.watchDir(t); e != nil {
 				return count, e
 			}
 		}
 	}
+
+	// Files are watched via their parent directory, as editors often save by
+	// renaming a new file over the old one; a watch on the file itself would
+	// follow the old inode into oblivion.
+	for _, f := range files {
+		run.watchedFiles[filepath.Clean(f)] = true
+
+		parent := filepath.Dir(f)
+		if run.watchedDirs[parent] {
+			continue
+		}
+		if run.Features[flgDebugOutput] {
+			fmt.Fprintf(os.Stderr, "[debug] w: %s (for %s)\n", parent, f)
+		}
+		count++
+		if e := run.watchDir(parent); e != nil {
+			return count, e
+		}
+		run.fileOnlyDirs[parent] = true
+	}
 	return count, nil
 }
 
+// Directories whose events we'll see (at least initially): each directory
+// WatchTarget and the parent of each file WatchTarget.
+func (run *runDirective) watchRoots() []string {
+	roots := make([]string, 0, len(run.WatchTargets))
+	for _, t := range run.WatchTargets {
+		if info, e := os.Stat(t); e == nil && !info.IsDir() {
+			t = filepath.Dir(t)
+		}
+		roots = append(roots, t)
+	}
+	return roots
+}
+
+// Whether `path` is ignored per ignore files, under flgIgnoreFiles.
+func (run *runDirective) isIgnoredByFile(path string, isDir bool) bool {
+	if run.ignores == nil {
+		return false
+	}
+
+	rule := run.ignores.match(path, isDir)
+	if rule == nil {
+		return false
+	}
+	if run.Features[flgDebugOutput] {
+		fmt.Fprintf(os.Stderr, "[debug] IGNR %s: %s\n", path, rule)
+	}
+	return true
+}
+
+// Whether `e` comes from a directory we only watch on behalf of file
+// WatchTargets, yet isn't about one of those files.
+func (run *runDirective) isFileTargetNoise(e fsEvent) bool {
+	name := filepath.Clean(e.Name)
+	return run.fileOnlyDirs[filepath.Dir(name)] && !run.watchedFiles[name]
+}
+
+// Establishes a watch on `root` and every directory beneath it, returning the
+// number of directories added.
+//
+// If `found` is non-nil it's called for every path visited under root (but not
+// root itself); useful for discovering files that appeared before their parent
+// directory's watch could be established.
+func (run *runDirective) watchTree(root string, found func(path string)) (int, error) {
+	count := 0
+	e := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path != root && os.IsNotExist(err) {
+				return nil // lost a race with a deletion; nothing left to watch
+			}
+			return err
+		}
+
+		if found != nil && path != root {
+			found(path)
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if path != root && (run.isExcludedDir(path) || run.isIgnoredByFile(path, true /*isDir*/)) {
+			return filepath.SkipDir
+		}
+
+		count++
+		return run.watchDir(path)
+	})
+	return count, e
+}
+
+// Whether directory `path` should be left out of recursive watches, per -x
+// DIR_PATTERNs and defaultExcludeDirs.
+func (run *runDirective) isExcludedDir(path string) bool {
+	name := filepath.Base(path)
+	for _, excludes := range [][]string{run.ExcludeDirs, defaultExcludeDirs} {
+		for _, pattern := range excludes {
+			if matched, _ := filepath.Match(pattern, name); !matched {
+				continue
+			}
+			if run.Features[flgDebugOutput] {
+				fmt.Fprintf(os.Stderr, "[debug] excluding dir '%s' (per '%s')\n", path, pattern)
+			}
+			return true
+		}
+
+		if run.Features[flgNoDefaultExcludeDirs] {
+			break
+		}
+	}
+	return false
+}
+
+func (run *runDirective) watchDir(path string) error {
+	if e := run.fsWatcher.Add(path); e != nil {
+		return e
+	}
+	run.watchedDirs[filepath.Clean(path)] = true
+	return nil
+}
+
+// Drops watches for `root` and any watched directory beneath it.
+func (run *runDirective) unwatchTree(root string) {
+	root = filepath.Clean(root)
+	prefix := root + string(filepath.Separator)
+	for dir := range run.watchedDirs {
+		if dir != root && !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+
+		// inotify has likely cleaned up after itself already (eg: IN_DELETE_SELF),
+		// so failures here are expected and harmless.
+		run.fsWatcher.Remove(dir)
+		delete(run.watchedDirs, dir)
+
+		if run.Features[flgDebugOutput] {
+			fmt.Fprintf(os.Stderr, "[debug] unwatched: %s\n", dir)
+		}
+	}
+}
+
+// Keeps recursive watches in sync with directories created or removed after
+// setup. Any paths discovered inside a newly created directory are emitted to
+// `out` (subject to the usual filtering), as their own events would have been
+// missed before the directory's watch existed.
+func (run *runDirective) trackDirChanges(e fsEvent, out chan fsEvent) {
+	if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		if run.watchedDirs[filepath.Clean(e.Name)] {
+			run.unwatchTree(e.Name)
+		}
+		return
+	}
+
+	if e.Op&fsnotify.Create == 0 {
+		return
+	}
+	if info, err := os.Stat(e.Name); err != nil || !info.IsDir() {
+		return
+	}
+	if run.isExcludedDir(e.Name) || run.isIgnoredByFile(e.Abs, true /*isDir*/) {
+		return
+	}
+
+	var missed []fsEvent
+	added, err := run.watchTree(e.Name, func(path string) {
+		missed = append(missed, run.normalizeEvent(fsnotify.Event{Name: path, Op: fsnotify.Create}))
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"\t%s%s: failed watching new directory %s: %v\n",
+			run.label(), color.New(color.Bold, color.FgBlue).Sprintf("warning"), e.Name, err)
+	}
+	if run.Features[flgDebugOutput] {
+		fmt.Fprintf(os.Stderr, "[debug] watching %d new dir(s) under: %s\n", added, e.Name)
+	}
+
+	for _, m := range missed {
+		if run.isAccepted(m) {
+			out <- m
+		}
+	}
+}
+
 func (run *runDirective) reportEstablishedWatches(numWatchedDirs int) {
 	var recursiveMsg string
 	if run.Features[flgRecursiveWatch] {
@@ -53,9 +243,23 @@ func (run *runDirective) reportEstablishedWatches(numWatchedDirs int) {
 	if run.Features[flgClobberCommands] {
 		clobberMode = fmt.Sprintf(" (in %s mode)", color.RedString("clobber"))
 	}
-	fmt.Printf("%s%s%s:\n\t%s\n",
+
+	var pollMode string
+	if run.Features[flgPollingWatch] {
+		pollMode = fmt.Sprintf(" (%s every %s)", color.HiRedString("polling"), run.PollInterval)
+	}
+	fmt.Printf("%s%s%s%s%s:\n\t%s\n",
+		run.label(),
 		recursiveMsg,
 		color.HiGreenString("watching"),
+		pollMode,
 		clobberMode,
 		strings.Join(run.WatchTargets, ", "))
+
+	if v, ok := run.fsWatcher.(*watchView); ok {
+		if notified, polled := v.counts(); polled > 0 {
+			fmt.Printf("\t(%d dirs via inotify, %d via %s every %s)\n",
+				notified, polled, color.HiRedString("polling"), run.PollInterval)
+		}
+	}
 }
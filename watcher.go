@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Source of filesystem events, as consumed by setup() and watchFSEvents.
+//
+// Semantics follow fsnotify: adding a directory yields events for the
+// directory's immediate children (and the directory itself).
+type watcher interface {
+	Add(path string) error
+	Remove(path string) error
+	Close() error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+}
+
+// Kernel-notified watcher (eg: inotify); the default.
+type notifyWatcher struct {
+	w *fsnotify.Watcher
+}
+
+func newNotifyWatcher() (*notifyWatcher, error) {
+	w, e := fsnotify.NewWatcher()
+	if e != nil {
+		return nil, e
+	}
+	return &notifyWatcher{w: w}, nil
+}
+
+func (n *notifyWatcher) Add(path string) error         { return n.w.Add(path) }
+func (n *notifyWatcher) Remove(path string) error      { return n.w.Remove(path) }
+func (n *notifyWatcher) Close() error                  { return n.w.Close() }
+func (n *notifyWatcher) Events() <-chan fsnotify.Event { return n.w.Events }
+func (n *notifyWatcher) Errors() <-chan error          { return n.w.Errors }
+
+// Routes each directory to kernel notifications or to polling, depending on
+// whether the filesystem it lives on can be expected to notify us.
+type hybridWatcher struct {
+	notify *notifyWatcher
+	poll   *pollWatcher
+	debug  bool
+
+	events chan fsnotify.Event
+	errors chan error
+
+	mux    sync.Mutex
+	routes map[string]watcher
+}
+
+func newHybridWatcher(pollInterval time.Duration, debug bool) (*hybridWatcher, error) {
+	n, e := newNotifyWatcher()
+	if e != nil {
+		return nil, e
+	}
+
+	h := &hybridWatcher{
+		notify: n,
+		poll:   newPollWatcher(pollInterval),
+		debug:  debug,
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		routes: make(map[string]watcher),
+	}
+	for _, w := range []watcher{h.notify, h.poll} {
+		go h.forward(w)
+	}
+	return h, nil
+}
+
+func (h *hybridWatcher) forward(w watcher) {
+	for {
+		select {
+		case e, ok := <-w.Events():
+			if !ok {
+				return
+			}
+			h.events <- e
+		case err, ok := <-w.Errors():
+			if !ok {
+				return
+			}
+			h.errors <- err
+		}
+	}
+}
+
+func (h *hybridWatcher) Events() <-chan fsnotify.Event { return h.events }
+func (h *hybridWatcher) Errors() <-chan error          { return h.errors }
+
+func (h *hybridWatcher) Add(path string) error {
+	path = filepath.Clean(path)
+	remote, fsName, e := needsPolling(path)
+	if e != nil {
+		return e
+	}
+
+	var w watcher = h.notify
+	if remote {
+		w = h.poll
+		if h.debug {
+			fmt.Fprintf(os.Stderr, "[debug] polling %s (on %s)\n", path, fsName)
+		}
+	}
+	if e := w.Add(path); e != nil {
+		return e
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.routes[path] = w
+	return nil
+}
+
+func (h *hybridWatcher) Remove(path string) error {
+	path = filepath.Clean(path)
+
+	h.mux.Lock()
+	w, ok := h.routes[path]
+	delete(h.routes, path)
+	h.mux.Unlock()
+
+	if !ok {
+		return fmt.Errorf("can't remove non-existent watch: %s", path)
+	}
+	return w.Remove(path)
+}
+
+func (h *hybridWatcher) Close() error {
+	h.poll.Close()
+	return h.notify.Close()
+}
+
+// Whether directory `path` is watched via polling.
+func (h *hybridWatcher) isPolled(path string) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.routes[filepath.Clean(path)] == watcher(h.poll)
+}